@@ -0,0 +1,518 @@
+package jpegscaled
+
+import "io"
+
+// qeEntry is a row of table D.2: the probability estimate Qe of an index
+// into the table, and the indexes that follow the decoding of the less and
+// more probable symbols (LPS and MPS). switchMPS is whether the sense of the
+// MPS is inverted after an LPS.
+type qeEntry struct {
+	qe         int64
+	nlps, nmps uint8
+	switchMPS  bool
+}
+
+// qeTable is table D.2, followed by an entry whose estimate of 0.5 never
+// changes, as libjpeg's jaricom.c has it. That last entry is the state of
+// arithDecoder.fixedBin.
+var qeTable = [...]qeEntry{
+	{0x5a1d, 1, 1, true},
+	{0x2586, 14, 2, false},
+	{0x1114, 16, 3, false},
+	{0x080b, 18, 4, false},
+	{0x03d8, 20, 5, false},
+	{0x01da, 23, 6, false},
+	{0x00e5, 25, 7, false},
+	{0x006f, 28, 8, false},
+	{0x0036, 30, 9, false},
+	{0x001a, 33, 10, false},
+	{0x000d, 35, 11, false},
+	{0x0006, 9, 12, false},
+	{0x0003, 10, 13, false},
+	{0x0001, 12, 13, false},
+	{0x5a7f, 15, 15, true},
+	{0x3f25, 36, 16, false},
+	{0x2cf2, 38, 17, false},
+	{0x207c, 39, 18, false},
+	{0x17b9, 40, 19, false},
+	{0x1182, 42, 20, false},
+	{0x0cef, 43, 21, false},
+	{0x09a1, 45, 22, false},
+	{0x072f, 46, 23, false},
+	{0x055c, 48, 24, false},
+	{0x0406, 49, 25, false},
+	{0x0303, 51, 26, false},
+	{0x0240, 52, 27, false},
+	{0x01b1, 54, 28, false},
+	{0x0144, 56, 29, false},
+	{0x00f5, 57, 30, false},
+	{0x00b7, 59, 31, false},
+	{0x008a, 60, 32, false},
+	{0x0068, 62, 33, false},
+	{0x004e, 63, 34, false},
+	{0x003b, 32, 35, false},
+	{0x002c, 33, 9, false},
+	{0x5ae1, 37, 37, true},
+	{0x484c, 64, 38, false},
+	{0x3a0d, 65, 39, false},
+	{0x2ef1, 67, 40, false},
+	{0x261f, 68, 41, false},
+	{0x1f33, 69, 42, false},
+	{0x19a8, 70, 43, false},
+	{0x1518, 72, 44, false},
+	{0x1177, 73, 45, false},
+	{0x0e74, 74, 46, false},
+	{0x0bfb, 75, 47, false},
+	{0x09f8, 77, 48, false},
+	{0x0861, 78, 49, false},
+	{0x0706, 79, 50, false},
+	{0x05cd, 48, 51, false},
+	{0x04de, 50, 52, false},
+	{0x040f, 50, 53, false},
+	{0x0363, 51, 54, false},
+	{0x02d4, 52, 55, false},
+	{0x025c, 53, 56, false},
+	{0x01f8, 54, 57, false},
+	{0x01a4, 55, 58, false},
+	{0x0160, 56, 59, false},
+	{0x0125, 57, 60, false},
+	{0x00f6, 58, 61, false},
+	{0x00cb, 59, 62, false},
+	{0x00ab, 61, 63, false},
+	{0x008f, 61, 32, false},
+	{0x5b12, 65, 65, true},
+	{0x4d04, 80, 66, false},
+	{0x412c, 81, 67, false},
+	{0x37d8, 82, 68, false},
+	{0x2fe8, 83, 69, false},
+	{0x293c, 84, 70, false},
+	{0x2379, 86, 71, false},
+	{0x1edf, 87, 72, false},
+	{0x1aa9, 87, 73, false},
+	{0x174e, 72, 74, false},
+	{0x1424, 72, 75, false},
+	{0x119c, 74, 76, false},
+	{0x0f6b, 74, 77, false},
+	{0x0d51, 75, 78, false},
+	{0x0bb6, 77, 79, false},
+	{0x0a40, 77, 48, false},
+	{0x5832, 80, 81, true},
+	{0x4d1c, 88, 82, false},
+	{0x438e, 89, 83, false},
+	{0x3bdd, 90, 84, false},
+	{0x34ee, 91, 85, false},
+	{0x2eae, 92, 86, false},
+	{0x299a, 93, 87, false},
+	{0x2516, 86, 71, false},
+	{0x5570, 88, 89, true},
+	{0x4ca9, 95, 90, false},
+	{0x44d9, 96, 91, false},
+	{0x3e22, 97, 92, false},
+	{0x3824, 99, 93, false},
+	{0x32b4, 99, 94, false},
+	{0x2e17, 93, 86, false},
+	{0x56a8, 95, 96, true},
+	{0x4f46, 101, 97, false},
+	{0x47e5, 102, 98, false},
+	{0x41cf, 103, 99, false},
+	{0x3c3d, 104, 100, false},
+	{0x375e, 99, 93, false},
+	{0x5231, 105, 102, false},
+	{0x4c0f, 106, 103, false},
+	{0x4639, 107, 104, false},
+	{0x415e, 103, 99, false},
+	{0x5627, 105, 106, true},
+	{0x50e7, 108, 107, false},
+	{0x4b85, 109, 103, false},
+	{0x5597, 110, 109, false},
+	{0x504f, 111, 107, false},
+	{0x5a10, 110, 111, true},
+	{0x5522, 112, 109, false},
+	{0x59eb, 112, 111, true},
+	{0x5a1d, 113, 113, false},
+}
+
+// fixedState is the index of the last entry of qeTable.
+const fixedState = len(qeTable) - 1
+
+// arithDecoder is the state of the arithmetic decoder of a scan, as
+// specified in section D.2. It is reset at the start of every scan and
+// restart interval.
+type arithDecoder struct {
+	// c and a are the code and interval registers, and ct is the bit
+	// counter.
+	c, a int64
+	ct   int
+	// atMarker is set once the entropy-coded data has been read up to a
+	// marker. Zero bytes are decoded from then on, as the decoder may need
+	// more bits than the encoder flushed.
+	atMarker bool
+	// dcStats and acStats are the statistics areas of the DC and AC
+	// conditioning tables, indexed as in tables F.4 and F.5. Every bin holds
+	// an index into qeTable, with the sense of the MPS in its high bit.
+	dcStats [maxTh + 1][64]uint8
+	acStats [maxTh + 1][256]uint8
+	// fixedBin is the bin of the decisions with a fixed probability of 0.5,
+	// such as the sign of AC coefficients.
+	fixedBin uint8
+	// dcContext is the DC conditioning category of every component of the
+	// scan, as specified in section F.1.4.4.1.2, as an offset into its
+	// dcStats.
+	dcContext [maxComponents]int
+}
+
+// errShortArithData means that an unexpected EOF occurred while decoding
+// arithmetic-coded data.
+var errShortArithData = FormatError("short arithmetic-coded data")
+
+// resetArith resets the arithmetic decoder for a new scan or restart
+// interval.
+func (d *decoder) resetArith() {
+	d.arith = arithDecoder{ct: -16, fixedBin: uint8(fixedState)}
+}
+
+// processDAC processes a Define Arithmetic Conditioning marker. Specified in
+// section B.2.4.3.
+func (d *decoder) processDAC(n int) error {
+	for ; n > 0; n -= 2 {
+		if n < 2 {
+			return FormatError("DAC has wrong length")
+		}
+		if err := d.readFull(d.tmp[:2]); err != nil {
+			return err
+		}
+		tc, tb, cs := d.tmp[0]>>4, d.tmp[0]&0x0f, d.tmp[1]
+		if tc > maxTc {
+			return FormatError("bad Tc value")
+		}
+		if tb > maxTh {
+			return FormatError("bad Tb value")
+		}
+		// DC conditioning values hold the bounds L and U in their low and
+		// high 4 bits, and AC conditioning values the bound Kx.
+		if tc == dcTable && cs&0x0f > cs>>4 || tc == acTable && (cs < 1 || cs > 63) {
+			return FormatError("bad Cs value")
+		}
+		d.dac[tc][tb] = cs
+	}
+	return nil
+}
+
+// arithByte returns the next byte of entropy-coded data, or 0 once a marker
+// has been reached. The marker is left unread.
+func (d *decoder) arithByte() (byte, error) {
+	if d.arith.atMarker {
+		return 0, nil
+	}
+	x, err := d.readByte()
+	if err != nil || x != 0xff {
+		return x, arithDataError(err)
+	}
+	// As get_byte in jdarith.c does, skip any fill bytes before the stuffed
+	// zero or the marker code.
+	for x == 0xff {
+		if x, err = d.readByte(); err != nil {
+			return 0, arithDataError(err)
+		}
+	}
+	if x == 0x00 {
+		return 0xff, nil
+	}
+	// Unread the marker's code and last 0xff byte, which fill keeps in the
+	// buffer.
+	d.bytes.i -= 2
+	d.arith.atMarker = true
+	return 0, nil
+}
+
+// arithDataError returns errShortArithData for the end of the data, and err
+// otherwise.
+func arithDataError(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return errShortArithData
+	}
+	return err
+}
+
+// arithDecode decodes a binary decision with the probability estimate of the
+// statistics bin st, and updates the estimate. Specified in section D.2.
+func (d *decoder) arithDecode(st *uint8) (int, error) {
+	e := &d.arith
+	// Renormalize, reading data as needed, as per section D.2.6.
+	for e.a < 0x8000 {
+		if e.ct--; e.ct < 0 {
+			x, err := d.arithByte()
+			if err != nil {
+				return 0, err
+			}
+			e.c = e.c<<8 | int64(x)
+			if e.ct += 8; e.ct < 0 {
+				// The first two bytes fill c before decoding starts.
+				if e.ct++; e.ct == 0 {
+					e.a = 0x8000
+				}
+			}
+		}
+		e.a <<= 1
+	}
+
+	sv := *st
+	q := &qeTable[sv&0x7f]
+	nlps := q.nlps
+	if q.switchMPS {
+		nlps ^= 0x80
+	}
+	e.a -= q.qe
+	if t := e.a << e.ct; e.c >= t {
+		// The decision falls in the LPS subinterval, which may be larger
+		// than the MPS one, in which case the symbols are exchanged.
+		e.c -= t
+		if e.a < q.qe {
+			*st = sv&0x80 | q.nmps
+		} else {
+			*st = sv&0x80 ^ nlps
+			sv ^= 0x80
+		}
+		e.a = q.qe
+	} else if e.a < 0x8000 {
+		if e.a < q.qe {
+			*st = sv&0x80 ^ nlps
+			sv ^= 0x80
+		} else {
+			*st = sv&0x80 | q.nmps
+		}
+	}
+	return int(sv >> 7), nil
+}
+
+// decodeArithBlock decodes the coefficients of a block that a scan holds,
+// as specified in sections F.2.4 and G.2. b holds the coefficients decoded
+// so far, i is the index of the block's component in the scan and pred is
+// its DC prediction.
+func (d *decoder) decodeArithBlock(b *block, sh *scanHeader, i int, pred *int32) error {
+	sc := &sh.comp[i]
+	zigStart := sh.zigStart
+	if zigStart == 0 {
+		if sh.ah == 0 {
+			diff, err := d.decodeArithDC(sc.td, i)
+			if err != nil {
+				return err
+			}
+			*pred += diff
+			b[0] = *pred << sh.al
+		} else {
+			bit, err := d.arithDecode(&d.arith.fixedBin)
+			if err != nil {
+				return err
+			}
+			b[0] |= int32(bit) << sh.al
+		}
+		if sh.zigEnd == 0 {
+			return nil
+		}
+		zigStart = 1
+	}
+	if sh.ah == 0 {
+		return d.decodeArithAC(b, sc.ta, zigStart, sh.zigEnd, sh.al)
+	}
+	return d.refineArithAC(b, sc.ta, zigStart, sh.zigEnd, sh.al)
+}
+
+// decodeArithDC returns the difference of a DC coefficient from its
+// prediction, as specified in section F.2.4.1. i is the index of the
+// block's component in the scan.
+func (d *decoder) decodeArithDC(tbl uint8, i int) (int32, error) {
+	st := &d.arith.dcStats[tbl]
+	ctx := &d.arith.dcContext[i]
+	s := *ctx
+	if bit, err := d.arithDecode(&st[s]); err != nil || bit == 0 {
+		*ctx = 0
+		return 0, err
+	}
+	sign, err := d.arithDecode(&st[s+1])
+	if err != nil {
+		return 0, err
+	}
+	s += 2 + sign
+	m, err := d.arithDecode(&st[s])
+	if err != nil {
+		return 0, err
+	}
+	if m != 0 {
+		if m, s, err = d.decodeArithMagnitude(st[:], 20, m); err != nil {
+			return 0, err
+		}
+	}
+
+	// Establish the conditioning category of the next difference, as per
+	// section F.1.4.4.1.2.
+	l, u := d.dac[dcTable][tbl]&0x0f, d.dac[dcTable][tbl]>>4
+	switch {
+	case m < (1<<l)>>1:
+		*ctx = 0
+	case m > (1<<u)>>1:
+		*ctx = 12 + 4*sign
+	default:
+		*ctx = 4 + 4*sign
+	}
+	return d.decodeArithValue(st[:], s+14, m, sign)
+}
+
+// decodeArithAC decodes the AC coefficients from zigStart to zigEnd, as
+// specified in sections F.2.4.2 and G.2.
+func (d *decoder) decodeArithAC(b *block, tbl uint8, zigStart, zigEnd int32, al uint32) error {
+	st := &d.arith.acStats[tbl]
+	kx := int32(d.dac[acTable][tbl])
+	for k := zigStart; k <= zigEnd; k++ {
+		s := 3 * int(k-1)
+		if eob, err := d.arithDecode(&st[s]); err != nil {
+			return err
+		} else if eob != 0 {
+			break
+		}
+		for {
+			nonZero, err := d.arithDecode(&st[s+1])
+			if err != nil {
+				return err
+			}
+			if nonZero != 0 {
+				break
+			}
+			s += 3
+			if k++; k > zigEnd {
+				return FormatError("too many coefficients")
+			}
+		}
+		sign, err := d.arithDecode(&d.arith.fixedBin)
+		if err != nil {
+			return err
+		}
+		s += 2
+		m, err := d.arithDecode(&st[s])
+		if err != nil {
+			return err
+		}
+		if m != 0 {
+			bit, err := d.arithDecode(&st[s])
+			if err != nil {
+				return err
+			}
+			if bit != 0 {
+				// The rest of the magnitude category is decoded with the
+				// bins that Kx selects, as per table F.5.
+				x := 189
+				if k > kx {
+					x = 217
+				}
+				if m, s, err = d.decodeArithMagnitude(st[:], x, 2); err != nil {
+					return err
+				}
+			}
+		}
+		v, err := d.decodeArithValue(st[:], s+14, m, sign)
+		if err != nil {
+			return err
+		}
+		b[unzig[k]] = v << al
+	}
+	return nil
+}
+
+// decodeArithMagnitude decodes the rest of the magnitude category of a
+// value, from bin x of st on, as specified in figure F.23. m is 1 shifted left
+// by the category decoded so far. It returns the category in the same form,
+// and the bin that the magnitude bits are decoded relative to.
+func (d *decoder) decodeArithMagnitude(st []uint8, x, m int) (int, int, error) {
+	for {
+		bit, err := d.arithDecode(&st[x])
+		if err != nil {
+			return 0, 0, err
+		}
+		if bit == 0 {
+			return m, x, nil
+		}
+		if m <<= 1; m == 0x8000 {
+			return 0, 0, FormatError("bad arithmetic code")
+		}
+		x++
+	}
+}
+
+// decodeArithValue decodes the low bits of a value of magnitude category m
+// with bin s of st, as specified in figure F.24, and applies its sign.
+func (d *decoder) decodeArithValue(st []uint8, s, m, sign int) (int32, error) {
+	v := m
+	for m >>= 1; m != 0; m >>= 1 {
+		bit, err := d.arithDecode(&st[s])
+		if err != nil {
+			return 0, err
+		}
+		if bit != 0 {
+			v |= m
+		}
+	}
+	v++
+	if sign != 0 {
+		v = -v
+	}
+	return int32(v), nil
+}
+
+// refineArithAC decodes a successive approximation refinement of the AC
+// coefficients from zigStart to zigEnd, as specified in section G.2.
+func (d *decoder) refineArithAC(b *block, tbl uint8, zigStart, zigEnd int32, al uint32) error {
+	st := &d.arith.acStats[tbl]
+	p1 := int32(1) << al
+	// kex is the end of block of the previous stage.
+	kex := zigEnd
+	for ; kex > 0 && b[unzig[kex]] == 0; kex-- {
+	}
+	for k := zigStart; k <= zigEnd; k++ {
+		s := 3 * int(k-1)
+		if k > kex {
+			if eob, err := d.arithDecode(&st[s]); err != nil {
+				return err
+			} else if eob != 0 {
+				break
+			}
+		}
+		for {
+			coef := &b[unzig[k]]
+			if *coef != 0 {
+				// Refine a coefficient that is already non-zero.
+				bit, err := d.arithDecode(&st[s+2])
+				if err != nil {
+					return err
+				}
+				if bit != 0 {
+					if *coef < 0 {
+						*coef -= p1
+					} else {
+						*coef += p1
+					}
+				}
+				break
+			}
+			nonZero, err := d.arithDecode(&st[s+1])
+			if err != nil {
+				return err
+			}
+			if nonZero != 0 {
+				sign, err := d.arithDecode(&d.arith.fixedBin)
+				if err != nil {
+					return err
+				}
+				*coef = p1
+				if sign != 0 {
+					*coef = -p1
+				}
+				break
+			}
+			s += 3
+			if k++; k > zigEnd {
+				return FormatError("too many coefficients")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestDecodeArithmetic tests that arithmetic-coded images, transcoded from
+// Huffman-coded ones by libjpeg, decode to the same images at every scale.
+func TestDecodeArithmetic(t *testing.T) {
+	for _, tc := range []struct {
+		filename, huffman string
+		jpegType          JpegType
+	}{
+		// Non-default DAC conditioning values.
+		{"testdata/video-001.arith.jpeg", "testdata/video-001.jpeg", JpegTypeExtended},
+		{"testdata/video-001.q50.420.arith.progressive.jpeg", "testdata/video-001.q50.420.jpeg", JpegTypeProgressive},
+		{"testdata/video-005.gray.q50.arith.rst5.jpeg", "testdata/video-005.gray.q50.jpeg", JpegTypeExtended},
+	} {
+		data, err := os.ReadFile(tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.filename, err)
+		}
+		if !cfg.Arithmetic || cfg.JpegType != tc.jpegType {
+			t.Errorf("%s: got arithmetic %t, type %d, want true, %d", tc.filename, cfg.Arithmetic, cfg.JpegType, tc.jpegType)
+		}
+		for _, opts := range []DecodeOptions{
+			{},
+			{DCTSizeScaled: 3},
+			{DCTSizeScaled: 1},
+			{DCTSizeScaled: 16, Concurrency: 4},
+		} {
+			want, err := decodeFileWithOptions(tc.huffman, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := Decode(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Errorf("%s %+v: %v", tc.filename, opts, err)
+				continue
+			}
+			if err := equalImages(m, want); err != nil {
+				t.Errorf("%s %+v: %v", tc.filename, opts, err)
+			}
+		}
+	}
+}
+
+// TestDecodeArithmeticTruncated tests that truncated arithmetic-coded images
+// are rejected, or decoded up to the truncation in tolerant mode.
+func TestDecodeArithmeticTruncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.arith.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:len(data)*2/3]
+	if _, err := Decode(bytes.NewReader(data), DecodeOptions{}); err == nil {
+		t.Error("strict: got nil error")
+	}
+	m, err := Decode(bytes.NewReader(data), DecodeOptions{Tolerant: true})
+	if err != nil {
+		t.Fatalf("tolerant: %v", err)
+	}
+	if got := m.Bounds(); got.Dx() != 150 || got.Dy() != 103 {
+		t.Errorf("tolerant: got bounds %v", got)
+	}
+}
+
+// TestDecodeArithmeticFillBytes tests that fill bytes before the stuffed
+// zeros and restart markers of arithmetic-coded data are skipped.
+func TestDecodeArithmeticFillBytes(t *testing.T) {
+	for _, filename := range []string{
+		"testdata/video-001.arith.jpeg",
+		"testdata/video-005.gray.q50.arith.rst5.jpeg",
+	} {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Decode(bytes.NewReader(data), DecodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sos := bytes.Index(data, []byte{0xff, sosMarker})
+		filled := append([]byte(nil), data[:sos+2]...)
+		for _, x := range data[sos+2:] {
+			if x == 0xff {
+				filled = append(filled, 0xff, 0xff)
+			}
+			filled = append(filled, x)
+		}
+		m, err := Decode(bytes.NewReader(filled), DecodeOptions{})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if err := equalImages(m, want); err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+	}
+}
+
+func TestProcessDAC(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		dac  []byte
+		ok   bool
+	}{
+		{"DC and AC", []byte{0x00, 0x41, 0x13, 0x3f}, true},
+		{"odd length", []byte{0x00, 0x41, 0x13}, false},
+		{"L above U", []byte{0x01, 0x14}, false},
+		{"Kx 0", []byte{0x10, 0x00}, false},
+		{"Kx 64", []byte{0x10, 0x40}, false},
+		{"bad Tc", []byte{0x20, 0x05}, false},
+		{"bad Tb", []byte{0x04, 0x10}, false},
+	} {
+		d := &decoder{r: bytes.NewReader(tc.dac)}
+		err := d.processDAC(len(tc.dac))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v", tc.desc, err)
+			continue
+		}
+		if tc.ok && (d.dac[dcTable][0] != 0x41 || d.dac[acTable][3] != 0x3f) {
+			t.Errorf("%s: got %v", tc.desc, d.dac)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package jpegscaled
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// curve maps a value in [0, 1] to another.
+type curve func(float64) float64
+
+// cmykToSRGB converts CMYK samples to sRGB through the A2B0 transform of a
+// CMYK ICC profile, which maps them to the profile connection space, and
+// from there to sRGB. The transform is one of the lut8Type, lut16Type and
+// lutAToBType pipelines, as specified in the ICC specification, sections
+// 10.8 to 10.10.
+type cmykToSRGB struct {
+	// in holds the result of the input curves for every 8-bit ink value.
+	in   [4][256]float64
+	clut clut
+	// m and matrix are the M curves and the matrix of a lutAToBType, or nil.
+	// The matrix is 3x3, followed by 3 offsets.
+	m      *[3]curve
+	matrix *[12]float64
+	// out are the output curves, or the B curves of a lutAToBType.
+	out [3]curve
+	// pcs decodes the output to CIE XYZ relative to D50.
+	pcs func(v [3]float64) [3]float64
+}
+
+// clut is a colour lookup table with 4 inputs and 3 outputs.
+type clut struct {
+	grid [4]int
+	// data holds the outputs of every grid point, normalized to [0, 1]. The
+	// first input varies least rapidly.
+	data []float64
+}
+
+// newCMYKToSRGB returns the conversion from the colour space of a CMYK ICC
+// profile to sRGB. It returns false if the profile is not a CMYK profile with
+// a supported A2B0 transform.
+func newCMYKToSRGB(profile []byte) (*cmykToSRGB, bool) {
+	tags, ok := iccTags(profile)
+	if !ok || string(profile[16:20]) != "CMYK" {
+		return nil, false
+	}
+	lab := false
+	switch string(profile[20:24]) {
+	case "Lab ":
+		lab = true
+	case "XYZ ":
+	default:
+		return nil, false
+	}
+	tag := tags["A2B0"]
+	if len(tag) < 32 || tag[8] != 4 || tag[9] != 3 {
+		return nil, false
+	}
+
+	c := new(cmykToSRGB)
+	var in [4]curve
+	// lScale, abScale and xyzScale turn the normalized output into PCS
+	// values, which are encoded differently by every tag type.
+	var lScale, abScale, xyzScale float64
+	switch string(tag[:4]) {
+	case "mft1", "mft2":
+		// p is the offset of the input tables, after the header, and the
+		// table sizes of an mft2.
+		size, entries, p := 1, 256, 48
+		if string(tag[:4]) == "mft2" {
+			size, p = 2, 52
+		}
+		if len(tag) < p {
+			return nil, false
+		}
+		if size == 2 {
+			entries = int(binary.BigEndian.Uint16(tag[48:]))
+			// Lab is encoded with the legacy 16-bit encoding of ICC v2,
+			// where 0xff00 is 100 for L* and 255 for a* and b*.
+			lScale, abScale, xyzScale = 100*65535/65280.0, 65535/256.0, 65535/32768.0
+		} else {
+			lScale, abScale, xyzScale = 100, 255, 255/128.0
+		}
+		outEntries := entries
+		if size == 2 {
+			outEntries = int(binary.BigEndian.Uint16(tag[50:]))
+		}
+		g := int(tag[10])
+		if entries < 2 || outEntries < 2 || g < 2 {
+			return nil, false
+		}
+		read := func(n int) ([]float64, bool) {
+			if p+n*size > len(tag) {
+				return nil, false
+			}
+			v := make([]float64, n)
+			for i := range v {
+				if size == 2 {
+					v[i] = float64(binary.BigEndian.Uint16(tag[p+2*i:])) / 65535
+				} else {
+					v[i] = float64(tag[p+i]) / 255
+				}
+			}
+			p += n * size
+			return v, true
+		}
+		for i := range in {
+			table, ok := read(entries)
+			if !ok {
+				return nil, false
+			}
+			in[i] = tableCurve(table)
+		}
+		c.clut.grid = [4]int{g, g, g, g}
+		if c.clut.data, ok = read(g * g * g * g * 3); !ok {
+			return nil, false
+		}
+		for i := range c.out {
+			table, ok := read(outEntries)
+			if !ok {
+				return nil, false
+			}
+			c.out[i] = tableCurve(table)
+		}
+	case "mAB ":
+		lScale, abScale, xyzScale = 100, 255, 65535/32768.0
+		offset := func(i int) int { return int(binary.BigEndian.Uint32(tag[12+4*i:])) }
+		bOff, matrixOff, mOff, clutOff, aOff := offset(0), offset(1), offset(2), offset(3), offset(4)
+		if bOff == 0 || clutOff == 0 || aOff == 0 {
+			return nil, false
+		}
+		if !parseCurves(tag, aOff, in[:]) || !parseCurves(tag, bOff, c.out[:]) {
+			return nil, false
+		}
+		if mOff != 0 && matrixOff != 0 {
+			c.m = new([3]curve)
+			if !parseCurves(tag, mOff, c.m[:]) || matrixOff+48 > len(tag) {
+				return nil, false
+			}
+			c.matrix = new([12]float64)
+			for i := range c.matrix {
+				c.matrix[i] = s15Fixed16(tag[matrixOff+4*i:])
+			}
+		}
+		if clutOff+20 > len(tag) {
+			return nil, false
+		}
+		n, size := 3, int(tag[clutOff+16])
+		for i := range c.clut.grid {
+			c.clut.grid[i] = int(tag[clutOff+i])
+			if c.clut.grid[i] < 2 {
+				return nil, false
+			}
+			n *= c.clut.grid[i]
+		}
+		p := clutOff + 20
+		if size != 1 && size != 2 || p+n*size > len(tag) {
+			return nil, false
+		}
+		c.clut.data = make([]float64, n)
+		for i := range c.clut.data {
+			if size == 2 {
+				c.clut.data[i] = float64(binary.BigEndian.Uint16(tag[p+2*i:])) / 65535
+			} else {
+				c.clut.data[i] = float64(tag[p+i]) / 255
+			}
+		}
+	default:
+		return nil, false
+	}
+
+	for i := range c.in {
+		for x := range c.in[i] {
+			c.in[i][x] = in[i](float64(x) / 255)
+		}
+	}
+	if lab {
+		c.pcs = func(v [3]float64) [3]float64 {
+			return labToXYZ(v[0]*lScale, v[1]*abScale-128, v[2]*abScale-128)
+		}
+	} else {
+		c.pcs = func(v [3]float64) [3]float64 {
+			return [3]float64{v[0] * xyzScale, v[1] * xyzScale, v[2] * xyzScale}
+		}
+	}
+	return c, true
+}
+
+// parseCurves parses the len(curves) consecutive curveType or
+// parametricCurveType tags at offset in tag.
+func parseCurves(tag []byte, offset int, curves []curve) bool {
+	for i := range curves {
+		if offset >= len(tag) {
+			return false
+		}
+		n := trcSize(tag[offset:])
+		if n == 0 {
+			return false
+		}
+		f, ok := parseTRC(tag[offset : offset+min(n, len(tag)-offset)])
+		if !ok {
+			return false
+		}
+		curves[i] = f
+		offset += n
+	}
+	return true
+}
+
+// labToXYZ converts CIE L*a*b* to XYZ, both relative to D50.
+func labToXYZ(l, a, b float64) [3]float64 {
+	finv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	fy := (l + 16) / 116
+	return [3]float64{0.9642 * finv(fy+a/500), finv(fy), 0.8249 * finv(fy-b/200)}
+}
+
+// lookup interpolates the table at in, whose values are in [0, 1].
+func (t *clut) lookup(in [4]float64) (out [3]float64) {
+	var (
+		stride [4]int
+		frac   [4]float64
+		base   int
+	)
+	s := 3
+	for i := 3; i >= 0; i-- {
+		stride[i] = s
+		s *= t.grid[i]
+	}
+	for i, v := range in {
+		p := min(max(v, 0), 1) * float64(t.grid[i]-1)
+		k := min(int(p), t.grid[i]-2)
+		frac[i] = p - float64(k)
+		base += k * stride[i]
+	}
+	for corner := 0; corner < 16; corner++ {
+		w, off := 1.0, base
+		for i := range in {
+			if corner>>i&1 != 0 {
+				w *= frac[i]
+				off += stride[i]
+			} else {
+				w *= 1 - frac[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += w * t.data[off+o]
+		}
+	}
+	return out
+}
+
+// rgb converts a CMYK sample, where 0 is no ink, to 8-bit sRGB.
+func (c *cmykToSRGB) rgb(cmyk []byte) (r, g, b uint8) {
+	v := c.clut.lookup([4]float64{c.in[0][cmyk[0]], c.in[1][cmyk[1]], c.in[2][cmyk[2]], c.in[3][cmyk[3]]})
+	if c.matrix != nil {
+		for i := range v {
+			v[i] = c.m[i](v[i])
+		}
+		m := c.matrix
+		v = [3]float64{
+			m[0]*v[0] + m[1]*v[1] + m[2]*v[2] + m[9],
+			m[3]*v[0] + m[4]*v[1] + m[5]*v[2] + m[10],
+			m[6]*v[0] + m[7]*v[1] + m[8]*v[2] + m[11],
+		}
+	}
+	for i := range v {
+		v[i] = c.out[i](v[i])
+	}
+	xyz := c.pcs(v)
+	var rgb [3]uint8
+	for i := range rgb {
+		lin := xyzD50ToSRGB[i][0]*xyz[0] + xyzD50ToSRGB[i][1]*xyz[1] + xyzD50ToSRGB[i][2]*xyz[2]
+		k := int(math.Round(lin * (srgbEncodeSize - 1)))
+		rgb[i] = srgbEncode[min(max(k, 0), srgbEncodeSize-1)]
+	}
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// cmykToRGBA converts m to RGB in place and returns it as an RGBA image
+// sharing m's pixels. It uses the CMYK ICC profile if it is supported, and
+// color.CMYKToRGB otherwise.
+func cmykToRGBA(m *image.CMYK, profile []byte) *image.RGBA {
+	c, ok := newCMYKToSRGB(profile)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(pix); i += 4 {
+			var r, g, bl uint8
+			if ok {
+				r, g, bl = c.rgb(pix[i : i+4])
+			} else {
+				r, g, bl = color.CMYKToRGB(pix[i], pix[i+1], pix[i+2], pix[i+3])
+			}
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = r, g, bl, 0xff
+		}
+	}
+	return &image.RGBA{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect}
+}
@@ -0,0 +1,267 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"testing"
+)
+
+// srgbToLab converts an 8-bit sRGB colour to CIE L*a*b* relative to D50.
+func srgbToLab(rgb [3]uint8) (l, a, b float64) {
+	var lin, xyz [3]float64
+	for i := range lin {
+		lin[i] = srgbEOTF(float64(rgb[i]) / 255)
+	}
+	for i := range xyz {
+		xyz[i] = srgbPrimaries[i][0]*lin[0] + srgbPrimaries[i][1]*lin[1] + srgbPrimaries[i][2]*lin[2]
+	}
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return t*24389/27/116 + 16.0/116
+	}
+	fx, fy, fz := f(xyz[0]/0.9642), f(xyz[1]), f(xyz[2]/0.8249)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// naiveCorner returns the naive conversion of the CMYK sample whose inks are
+// fully on for the set bits of corner, the first ink being the highest bit.
+func naiveCorner(corner int) [3]uint8 {
+	var cmyk [4]uint8
+	for i := range cmyk {
+		if corner>>(3-i)&1 != 0 {
+			cmyk[i] = 255
+		}
+	}
+	r, g, b := color.CMYKToRGB(cmyk[0], cmyk[1], cmyk[2], cmyk[3])
+	return [3]uint8{r, g, b}
+}
+
+// cmykProfile returns a CMYK ICC profile with a Lab PCS whose A2B0 tag, of
+// type typ, has identity curves and a 2-point CLUT that maps every corner to
+// the Lab of its naive conversion.
+func cmykProfile(typ string) []byte {
+	// enc encodes a Lab value as a fraction of the CLUT's maximum value.
+	var enc func(l, a, b float64) [3]float64
+	switch typ {
+	case "mft2":
+		enc = func(l, a, b float64) [3]float64 {
+			return [3]float64{l / 100 * 65280 / 65535, (a + 128) * 256 / 65535, (b + 128) * 256 / 65535}
+		}
+	default:
+		enc = func(l, a, b float64) [3]float64 {
+			return [3]float64{l / 100, (a + 128) / 255, (b + 128) / 255}
+		}
+	}
+	size := 2
+	if typ == "mft1" {
+		size = 1
+	}
+	appendValue := func(tag []byte, v float64) []byte {
+		if size == 1 {
+			return append(tag, uint8(math.Round(min(max(v, 0), 1)*255)))
+		}
+		return binary.BigEndian.AppendUint16(tag, uint16(math.Round(min(max(v, 0), 1)*65535)))
+	}
+	var grid []byte
+	for corner := 0; corner < 16; corner++ {
+		for _, v := range enc(srgbToLab(naiveCorner(corner))) {
+			grid = appendValue(grid, v)
+		}
+	}
+	identity := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	tag := append([]byte(typ), 0, 0, 0, 0, 4, 3)
+	switch typ {
+	case "mft1", "mft2":
+		tag = append(tag, 2, 0)
+		for i := 0; i < 9; i++ {
+			v := 0.0
+			if i%4 == 0 {
+				v = 1
+			}
+			tag = appendS15Fixed16(tag, v)
+		}
+		entries := 256
+		if typ == "mft2" {
+			entries = 2
+			tag = binary.BigEndian.AppendUint16(tag, 2)
+			tag = binary.BigEndian.AppendUint16(tag, 2)
+		}
+		ramp := func(tag []byte) []byte {
+			for i := 0; i < entries; i++ {
+				tag = appendValue(tag, float64(i)/float64(entries-1))
+			}
+			return tag
+		}
+		for i := 0; i < 4; i++ {
+			tag = ramp(tag)
+		}
+		tag = append(tag, grid...)
+		for i := 0; i < 3; i++ {
+			tag = ramp(tag)
+		}
+	case "mAB ":
+		// B curves, no matrix or M curves, CLUT, A curves.
+		bOff, clutOff := 32, 32+3*len(identity)
+		aOff := clutOff + 20 + len(grid)
+		tag = append(tag, 0, 0)
+		for _, off := range []int{bOff, 0, 0, clutOff, aOff} {
+			tag = binary.BigEndian.AppendUint32(tag, uint32(off))
+		}
+		for i := 0; i < 3; i++ {
+			tag = append(tag, identity...)
+		}
+		tag = append(tag, 2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0)
+		tag = append(tag, grid...)
+		for i := 0; i < 4; i++ {
+			tag = append(tag, identity...)
+		}
+	}
+	for len(tag)%4 != 0 {
+		tag = append(tag, 0)
+	}
+
+	profile := make([]byte, 128)
+	copy(profile[16:], "CMYKLab ")
+	copy(profile[36:], "acsp")
+	profile = binary.BigEndian.AppendUint32(profile, 1)
+	profile = append(profile, "A2B0"...)
+	profile = binary.BigEndian.AppendUint32(profile, 144)
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(tag)))
+	profile = append(profile, tag...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+// TestCMYKToSRGB tests that every supported A2B0 tag type maps the corners of
+// the CLUT to the colours they were built from.
+func TestCMYKToSRGB(t *testing.T) {
+	for _, tc := range []struct {
+		typ       string
+		tolerance int
+	}{
+		// The 8-bit Lab encoding is coarse, and its rounding is amplified by
+		// the steep sRGB curve near 0.
+		{"mft1", 12},
+		{"mft2", 1},
+		{"mAB ", 1},
+	} {
+		c, ok := newCMYKToSRGB(cmykProfile(tc.typ))
+		if !ok {
+			t.Errorf("%q: not parsed", tc.typ)
+			continue
+		}
+		for corner := 0; corner < 16; corner++ {
+			want := naiveCorner(corner)
+			var cmyk [4]byte
+			for i := range cmyk {
+				cmyk[i] = uint8(255 * (corner >> (3 - i) & 1))
+			}
+			r, g, b := c.rgb(cmyk[:])
+			for i, got := range []uint8{r, g, b} {
+				if d := int(got) - int(want[i]); d < -tc.tolerance || d > tc.tolerance {
+					t.Errorf("%q: CMYK %v: got RGB %v, want %v", tc.typ, cmyk, []uint8{r, g, b}, want)
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeCMYKToRGB tests that CMYKToRGB gives the naive conversion of
+// the CMYK image without a profile, and uses the profile when there is one.
+func TestDecodeCMYKToRGB(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.cmyk.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := cmykProfile("mft2")
+	for _, withProfile := range []bool{false, true} {
+		input := data
+		if withProfile {
+			input = insertSegment(data, iccSegment(profile, 1, 1))
+		}
+		opts := DecodeOptions{DCTSizeScaled: 4}
+		plain, err := Decode(bytes.NewReader(input), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmyk, ok := plain.(*image.CMYK)
+		if !ok {
+			t.Fatalf("got %T, want *image.CMYK", plain)
+		}
+		var want *image.RGBA
+		if withProfile {
+			want = cmykToRGBA(cmyk, profile)
+		} else {
+			want = image.NewRGBA(cmyk.Rect)
+			for y := cmyk.Rect.Min.Y; y < cmyk.Rect.Max.Y; y++ {
+				for x := cmyk.Rect.Min.X; x < cmyk.Rect.Max.X; x++ {
+					want.Set(x, y, cmyk.At(x, y))
+				}
+			}
+		}
+		opts.CMYKToRGB = true
+		m, err := Decode(bytes.NewReader(input), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m.(*image.RGBA); !ok {
+			t.Fatalf("profile %t: got %T, want *image.RGBA", withProfile, m)
+		}
+		if err := equalImages(m, want); err != nil {
+			t.Errorf("profile %t: %v", withProfile, err)
+		}
+	}
+}
+
+// truncateA2B0 returns profile, as made by cmykProfile, with its A2B0 tag
+// cut to n bytes.
+func truncateA2B0(profile []byte, n int) []byte {
+	p := append([]byte(nil), profile[:144+n]...)
+	binary.BigEndian.PutUint32(p[140:], uint32(n))
+	binary.BigEndian.PutUint32(p, uint32(len(p)))
+	return p
+}
+
+// TestCMYKToSRGBTruncated tests that A2B0 tags too short for their header
+// are rejected, and that decoding then falls back to the naive conversion.
+func TestCMYKToSRGBTruncated(t *testing.T) {
+	for _, typ := range []string{"mft1", "mft2"} {
+		for n := 32; n < 52; n++ {
+			if _, ok := newCMYKToSRGB(truncateA2B0(cmykProfile(typ), n)); ok {
+				t.Errorf("%q of %d bytes: parsed", typ, n)
+			}
+		}
+	}
+
+	data, err := os.ReadFile("testdata/video-001.cmyk.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := insertSegment(data, iccSegment(truncateA2B0(cmykProfile("mft2"), 40), 1, 1))
+	m, err := Decode(bytes.NewReader(input), DecodeOptions{CMYKToRGB: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := Decode(bytes.NewReader(data), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmyk := plain.(*image.CMYK)
+	want := image.NewRGBA(cmyk.Rect)
+	for y := cmyk.Rect.Min.Y; y < cmyk.Rect.Max.Y; y++ {
+		for x := cmyk.Rect.Min.X; x < cmyk.Rect.Max.X; x++ {
+			want.Set(x, y, cmyk.At(x, y))
+		}
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,96 @@
+package jpegscaled
+
+// coeffStore holds the partially decoded coefficients of one component of a
+// progressive image between scans.
+//
+// Only the top-left kw x kh coefficients of every block, the lowest
+// frequencies, are kept, as the scaled IDCT never uses the others. They are
+// stored as int16, which holds any coefficient of a valid image. Successive
+// approximation refinement also needs to know which of the discarded
+// coefficients are non-zero, so that is recorded in a bit mask per block.
+type coeffStore struct {
+	kw, kh  int
+	coeffs  []int16  // kw*kh coefficients per block, in natural order.
+	nonzero []uint64 // Bit i is set if coefficient i, in natural order, is non-zero.
+}
+
+// newCoeffStore returns a store for n blocks, keeping the coefficients that
+// a w x h IDCT uses.
+func newCoeffStore(n, w, h int) *coeffStore {
+	kw, kh := min(w, DCTSIZE), min(h, DCTSIZE)
+	return &coeffStore{
+		kw:      kw,
+		kh:      kh,
+		coeffs:  make([]int16, n*kw*kh),
+		nonzero: make([]uint64, n),
+	}
+}
+
+// load copies the coefficients of block i into b. The discarded
+// coefficients are zero, unless markNonzero is true: then those that are
+// non-zero are set to 1, so that refine treats them as having history and
+// storing b again keeps them marked as non-zero.
+func (s *coeffStore) load(i int, b *block, markNonzero bool) {
+	*b = block{}
+	c := s.coeffs[i*s.kw*s.kh:]
+	for y := 0; y < s.kh; y++ {
+		for x := 0; x < s.kw; x++ {
+			b[y*DCTSIZE+x] = int32(c[y*s.kw+x])
+		}
+	}
+	if markNonzero && (s.kw < DCTSIZE || s.kh < DCTSIZE) {
+		for nz, k := s.nonzero[i], 0; nz != 0; nz, k = nz>>1, k+1 {
+			if nz&1 != 0 && b[k] == 0 {
+				b[k] = 1
+			}
+		}
+	}
+}
+
+// store saves the coefficients in b as those of block i.
+func (s *coeffStore) store(i int, b *block) {
+	c := s.coeffs[i*s.kw*s.kh:]
+	for y := 0; y < s.kh; y++ {
+		for x := 0; x < s.kw; x++ {
+			c[y*s.kw+x] = int16(b[y*DCTSIZE+x])
+		}
+	}
+	nz := uint64(0)
+	for k, v := range b {
+		if v != 0 {
+			nz |= 1 << k
+		}
+	}
+	s.nonzero[i] = nz
+}
+
+// recordScan updates d.progBits after the progressive scan sh was decoded.
+func (d *decoder) recordScan(sh *scanHeader) {
+	for i := 0; i < sh.nComp; i++ {
+		bits := &d.progBits[sh.comp[i].compIndex]
+		for zig := sh.zigStart; zig <= sh.zigEnd; zig++ {
+			bits[zig] = int8(sh.al) + 1
+		}
+	}
+}
+
+// progressiveComplete returns whether decoding may stop, because stopping
+// early is enabled and every coefficient that the scaled IDCT uses is
+// complete for every component.
+func (d *decoder) progressiveComplete() bool {
+	if !d.stopEarly && (d.dctSizeScaledX != 1 || d.dctSizeScaledY != 1) {
+		return false
+	}
+	for i := 0; i < d.nComp; i++ {
+		s := d.progCoeffs[i]
+		if s == nil {
+			return false
+		}
+		for zig, k := range unzig {
+			if k%DCTSIZE < s.kw && k/DCTSIZE < s.kh && d.progBits[i][zig] != 1 {
+				return false
+			}
+		}
+	}
+	return true
+}
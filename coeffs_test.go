@@ -0,0 +1,36 @@
+package jpegscaled
+
+import "testing"
+
+func TestCoeffStore(t *testing.T) {
+	s := newCoeffStore(3, 2, 3)
+	if len(s.coeffs) != 3*2*3 {
+		t.Fatalf("got %d coefficients, want %d", len(s.coeffs), 3*2*3)
+	}
+	var b block
+	b[0], b[1], b[2], b[8], b[17], b[63] = 100, -5, 7, 3, -2, 1
+	s.store(1, &b)
+
+	var got block
+	s.load(1, &got, false)
+	want := block{}
+	want[0], want[1], want[8], want[17] = 100, -5, 3, -2
+	if got != want {
+		t.Errorf("load: got %v, want %v", got, want)
+	}
+	// The discarded coefficients 2 and 63 keep being marked as non-zero.
+	s.load(1, &got, true)
+	want[2], want[63] = 1, 1
+	if got != want {
+		t.Errorf("load marking non-zero: got %v, want %v", got, want)
+	}
+	s.store(1, &got)
+	if s.nonzero[1] != 1<<0|1<<1|1<<2|1<<8|1<<17|1<<63 {
+		t.Errorf("got non-zero mask %#x", s.nonzero[1])
+	}
+	// The neighbouring blocks are untouched.
+	s.load(0, &got, true)
+	if got != (block{}) {
+		t.Errorf("block 0: got %v, want zero", got)
+	}
+}
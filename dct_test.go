@@ -79,6 +79,95 @@ func TestDCT(t *testing.T) {
 	}
 }
 
+func TestScaledIDCT(t *testing.T) {
+	blocks := make([]block, len(testBlocks))
+	copy(blocks, testBlocks[:])
+	r := rand.New(rand.NewSource(456))
+	for i := 0; i < 100; i++ {
+		b := block{}
+		n := r.Int() % 64
+		for j := 0; j < n; j++ {
+			b[r.Int()%len(b)] = r.Int31()%256 - 128
+		}
+		blocks = append(blocks, b)
+	}
+
+	qt := &block{}
+	for i := range qt {
+		qt[i] = 1
+	}
+	optimized := [DCTSIZE + 1]func(src, qt *block){
+		nil, jpeg_idct_1x1, jpeg_idct_2x2, jpeg_idct_3x3, jpeg_idct_4x4,
+		jpeg_idct_5x5, jpeg_idct_6x6, jpeg_idct_7x7, idct_slow,
+	}
+	for i, b := range blocks {
+		for w := 1; w <= maxDCTSize; w++ {
+			for h := 1; h <= maxDCTSize; h++ {
+				got := make([]int32, w*h)
+				jpeg_idct_scaled(&b, qt, got, w, h, 8)
+				if w == h && w <= DCTSIZE {
+					// The kernels are those of the hand-optimized routine.
+					opt := b
+					optimized[w](&opt, qt)
+					for j := range got {
+						if got[j] != opt[j] {
+							t.Fatalf("i=%d, %dx%d: sample %d: got %d, want %d", i, w, h, j, got[j], opt[j])
+						}
+					}
+				}
+				want := slowScaledIDCT(&b, w, h)
+				for j := range got {
+					if delta := got[j] - want[j]; delta < -2 || +2 < delta {
+						t.Fatalf("i=%d, %dx%d: sample %d: got %d, want %d", i, w, h, j, got[j], want[j])
+					}
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkScaledIDCT(b *testing.B) {
+	qt := &block{}
+	for i := range qt {
+		qt[i] = 1
+	}
+	src := testBlocks[0]
+	b.Run("idct_slow", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			blk := src
+			idct_slow(&blk, qt)
+		}
+	})
+	for _, size := range []struct{ w, h int }{{8, 8}, {12, 12}, {16, 16}, {16, 8}, {8, 4}} {
+		b.Run(fmt.Sprintf("%dx%d", size.w, size.h), func(b *testing.B) {
+			out := make([]int32, size.w*size.h)
+			for i := 0; i < b.N; i++ {
+				jpeg_idct_scaled(&src, qt, out, size.w, size.h, 8)
+			}
+		})
+	}
+}
+
+// slowScaledIDCT returns the w x h scaled IDCT of b, level shifted and
+// clamped to [0, 255], as computed with floating point arithmetic.
+func slowScaledIDCT(b *block, w, h int) []int32 {
+	dst := make([]int32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum := 0.0
+			for v := 0; v < min(h, 8); v++ {
+				for u := 0; u < min(w, 8); u++ {
+					sum += alpha(u) * alpha(v) * float64(b[8*v+u]) *
+						math.Cos(float64((2*x+1)*u)*math.Pi/float64(2*w)) *
+						math.Cos(float64((2*y+1)*v)*math.Pi/float64(2*h))
+				}
+			}
+			dst[w*y+x] = int32(min(max(math.Round(sum/8)+128, 0), 255))
+		}
+	}
+	return dst
+}
+
 // differ reports whether any pair-wise elements in b0 and b1 differ by 2 or
 // more. That tolerance is because there isn't a single definitive decoding of
 // a given JPEG image, even before the YCbCr to RGB conversion; implementations
@@ -13,6 +13,7 @@ import (
 	_ "image/png"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -109,6 +110,64 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+// TestDecodeConfigDetails tests the Config fields that describe the frame
+// and the segments before the first scan.
+func TestDecodeConfigDetails(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		want     Config
+	}{
+		{"testdata/video-001.q50.422.jpeg", Config{
+			Components:     []ComponentInfo{{1, 2, 1}, {2, 1, 1}, {3, 1, 1}},
+			SubsampleRatio: image.YCbCrSubsampleRatio422,
+			JFIF:           true, JFIFVersion: 0x0101, DensityUnit: DensityCentimeter, XDensity: 28, YDensity: 28,
+			Precision: 8, Scans: 1,
+		}},
+		{"testdata/video-001.q50.410.progressive.jpeg", Config{
+			Components:     []ComponentInfo{{1, 4, 2}, {2, 1, 1}, {3, 1, 1}},
+			SubsampleRatio: image.YCbCrSubsampleRatio410,
+			JFIF:           true, JFIFVersion: 0x0101, DensityUnit: DensityNone, XDensity: 1, YDensity: 1,
+			Precision: 8,
+		}},
+		{"testdata/video-001.rst3.jpeg", Config{
+			Components:      []ComponentInfo{{1, 2, 2}, {2, 1, 1}, {3, 1, 1}},
+			SubsampleRatio:  image.YCbCrSubsampleRatio420,
+			RestartInterval: 3, Precision: 8, Scans: 1,
+		}},
+		{"testdata/video-001.cmyk.jpeg", Config{
+			Components: []ComponentInfo{{'C', 1, 1}, {'M', 1, 1}, {'Y', 1, 1}, {'K', 1, 1}},
+			Adobe:      true, AdobeTransform: adobeTransformUnknown,
+			JFIF: true, JFIFVersion: 0x0101, DensityUnit: DensityInch, XDensity: 72, YDensity: 72,
+			Precision: 8, Scans: 1,
+		}},
+	} {
+		got, err := decodeConfig(tc.filename)
+		if err != nil {
+			t.Errorf("%s: %v", tc.filename, err)
+			continue
+		}
+		got.Config, got.JpegType = image.Config{}, 0
+		got.Quality, got.StandardQuant = 0, false
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tc.filename, got, tc.want)
+		}
+	}
+
+	// A full decode counts the scans of progressive images too.
+	f, err := os.Open("testdata/video-001.q50.410.progressive.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	res, err := DecodeResult(f, DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Config.Scans != 10 {
+		t.Errorf("DecodeResult: got %d scans, want 10", res.Config.Scans)
+	}
+}
+
 func decodeJpegScaled(filename string, dctSizeScaled int) (image.Image, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -0,0 +1,194 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// exifHeader starts the payload of an APP1 segment that holds EXIF data.
+const exifHeader = "Exif\x00\x00"
+
+// EXIF tags, as specified in the EXIF 2.3 specification, section 4.6.
+const (
+	tagOrientation           = 0x0112
+	tagJPEGInterchangeFormat = 0x0201 // Offset of the IFD1 JPEG thumbnail.
+	tagJPEGInterchangeLength = 0x0202 // Length of the IFD1 JPEG thumbnail.
+)
+
+// ErrNoThumbnail is returned by DecodeEmbeddedThumbnail for images with no
+// embedded JPEG thumbnail.
+var ErrNoThumbnail = errors.New("jpeg: no embedded JPEG thumbnail")
+
+// DecodeEmbeddedThumbnail reads the segments of a JPEG image from r up to its
+// frame header, and decodes the JPEG thumbnail stored in the IFD1 of its EXIF
+// data, or failing that, in a JFXX APP0 segment, as Decode would with opts.
+// The frame header, tables and entropy-coded data of the image itself are
+// never parsed, so images that Decode rejects may still have thumbnails. If
+// the thumbnail has no EXIF orientation of its own, AutoOrient uses that of
+// the image.
+func DecodeEmbeddedThumbnail(r io.Reader, opts DecodeOptions) (image.Image, error) {
+	d := decoder{appOnly: true}
+	if _, err := d.decode(r, false); err != nil {
+		return nil, err
+	}
+	thumb := d.thumbnail()
+	if thumb == nil {
+		thumb = d.jfxxJPEG
+	}
+	if thumb == nil {
+		return nil, ErrNoThumbnail
+	}
+	td := newDecoder(opts)
+	td.orientation = d.orientation
+	return td.decodeImage(bytes.NewReader(thumb), opts)
+}
+
+// thumbnail returns the JPEG thumbnail in d.exif, or nil if there is none.
+func (d *decoder) thumbnail() []byte {
+	t, ok := parseTIFF(d.exif)
+	if !ok {
+		return nil
+	}
+	ifd1 := t.next(t.ifd0())
+	e, ok := t.lookup(ifd1, tagJPEGInterchangeFormat)
+	if !ok {
+		return nil
+	}
+	offset, ok := t.uint(e)
+	if !ok {
+		return nil
+	}
+	if e, ok = t.lookup(ifd1, tagJPEGInterchangeLength); !ok {
+		return nil
+	}
+	n, ok := t.uint(e)
+	if !ok || n == 0 || uint64(offset)+uint64(n) > uint64(len(t.data)) {
+		return nil
+	}
+	return t.data[offset : offset+n]
+}
+
+// processApp1Marker reads an APP1 segment. If it is the first one that holds
+// EXIF data, that data is kept in d.exif and the orientation is parsed from
+// it. Other APP1 segments, such as XMP, are ignored.
+func (d *decoder) processApp1Marker(n int) error {
+	if n < len(exifHeader) || d.exif != nil {
+		return d.ignore(n)
+	}
+	if err := d.readFull(d.tmp[:len(exifHeader)]); err != nil {
+		return err
+	}
+	n -= len(exifHeader)
+	if string(d.tmp[:len(exifHeader)]) != exifHeader {
+		return d.ignore(n)
+	}
+	exif := make([]byte, n)
+	if err := d.readFull(exif); err != nil {
+		return err
+	}
+	d.exif = exif
+	if t, ok := parseTIFF(exif); ok {
+		if e, ok := t.lookup(t.ifd0(), tagOrientation); ok {
+			if o, ok := t.uint(e); ok && 1 <= o && o <= 8 {
+				d.orientation = int(o)
+			}
+		}
+	}
+	return nil
+}
+
+// tiff is the TIFF structure that EXIF data is stored in. Malformed data is
+// never an error: lookups just fail.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is an entry of a TIFF image file directory.
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	// value is the entry's 4-byte value field, which holds either the
+	// value itself or its offset.
+	value []byte
+}
+
+// TIFF field types.
+const (
+	tiffShort = 3
+	tiffLong  = 4
+)
+
+// parseTIFF checks the TIFF header at the start of b.
+func parseTIFF(b []byte) (tiff, bool) {
+	if len(b) < 8 {
+		return tiff{}, false
+	}
+	switch string(b[:4]) {
+	case "II*\x00":
+		return tiff{b, binary.LittleEndian}, true
+	case "MM\x00*":
+		return tiff{b, binary.BigEndian}, true
+	}
+	return tiff{}, false
+}
+
+// ifd0 returns the offset of the first image file directory.
+func (t tiff) ifd0() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+// next returns the offset of the image file directory that follows the one
+// at offset, or 0 if there is none.
+func (t tiff) next(offset uint32) uint32 {
+	if offset < 8 || uint64(offset)+2 > uint64(len(t.data)) {
+		return 0
+	}
+	n := uint64(t.order.Uint16(t.data[offset:]))
+	end := uint64(offset) + 2 + 12*n
+	if end+4 > uint64(len(t.data)) {
+		return 0
+	}
+	return t.order.Uint32(t.data[end:])
+}
+
+// lookup returns the entry for tag in the image file directory at offset.
+func (t tiff) lookup(offset uint32, tag uint16) (ifdEntry, bool) {
+	if offset < 8 || uint64(offset)+2 > uint64(len(t.data)) {
+		return ifdEntry{}, false
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	for i := 0; i < n; i++ {
+		p := uint64(offset) + 2 + 12*uint64(i)
+		if p+12 > uint64(len(t.data)) {
+			break
+		}
+		e := t.data[p : p+12]
+		if t.order.Uint16(e) == tag {
+			return ifdEntry{
+				tag:   tag,
+				typ:   t.order.Uint16(e[2:]),
+				count: t.order.Uint32(e[4:]),
+				value: e[8:12],
+			}, true
+		}
+	}
+	return ifdEntry{}, false
+}
+
+// uint returns the first value of a SHORT or LONG entry.
+func (t tiff) uint(e ifdEntry) (uint32, bool) {
+	if e.count == 0 {
+		return 0, false
+	}
+	switch e.typ {
+	case tiffShort:
+		return uint32(t.order.Uint16(e.value)), true
+	case tiffLong:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
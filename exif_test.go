@@ -0,0 +1,145 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// exifSegment returns an APP1 segment holding EXIF data in the given byte
+// order, whose IFD0 has an orientation tag with the value o. If thumb is
+// non-nil, it is stored as the IFD1 JPEG thumbnail.
+func exifSegment(order binary.AppendByteOrder, o uint16, thumb []byte) []byte {
+	tiff := []byte("II*\x00")
+	if order == binary.BigEndian {
+		tiff = []byte("MM\x00*")
+	}
+	entry := func(tiff []byte, tag, typ uint16, v uint32) []byte {
+		tiff = order.AppendUint16(tiff, tag)
+		tiff = order.AppendUint16(tiff, typ)
+		tiff = order.AppendUint32(tiff, 1)
+		if typ == tiffShort {
+			return order.AppendUint16(order.AppendUint16(tiff, uint16(v)), 0)
+		}
+		return order.AppendUint32(tiff, v)
+	}
+	// The header is followed by IFD0 at offset 8, IFD1 at 26 and the
+	// thumbnail at 56.
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = entry(tiff, tagOrientation, tiffShort, uint32(o))
+	if thumb == nil {
+		tiff = order.AppendUint32(tiff, 0)
+	} else {
+		tiff = order.AppendUint32(tiff, 26)
+		tiff = order.AppendUint16(tiff, 2)
+		tiff = entry(tiff, tagJPEGInterchangeFormat, tiffLong, 56)
+		tiff = entry(tiff, tagJPEGInterchangeLength, tiffLong, uint32(len(thumb)))
+		tiff = order.AppendUint32(tiff, 0)
+		tiff = append(tiff, thumb...)
+	}
+	payload := append([]byte(exifHeader), tiff...)
+	seg := []byte{0xff, app1Marker}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// insertSegment returns the JPEG data with seg inserted right after SOI.
+func insertSegment(data, seg []byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	out = append(out, seg...)
+	return append(out, data[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Orientation != 0 {
+		t.Errorf("no EXIF: got orientation %d, want 0", cfg.Orientation)
+	}
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := uint16(0); o <= 9; o++ {
+			want := int(o)
+			if o == 0 || o == 9 {
+				want = 0
+			}
+			cfg, err := DecodeConfig(bytes.NewReader(insertSegment(data, exifSegment(order, o, nil))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Orientation != want {
+				t.Errorf("%v %d: got orientation %d, want %d", order, o, cfg.Orientation, want)
+			}
+		}
+	}
+}
+
+// TestDecodeEmbeddedThumbnail tests that the EXIF thumbnail is decoded as
+// the same image on its own would be, without reading the image's scans.
+func TestDecodeEmbeddedThumbnail(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := os.ReadFile("testdata/video-005.gray.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := exifSegment(binary.LittleEndian, 1, thumb)
+	input := insertSegment(data, seg)
+	// Cut the input right after the image's first SOS segment.
+	sos := 2 + len(seg) + bytes.Index(data[2:], []byte{0xff, sosMarker})
+	input = input[:sos+2+int(input[sos+2])<<8+int(input[sos+3])]
+
+	opts := DecodeOptions{DCTSizeScaled: 4}
+	want, err := Decode(bytes.NewReader(thumb), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeEmbeddedThumbnail(bytes.NewReader(input), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Error(err)
+	}
+
+	// The thumbnail has no EXIF data, so it takes the image's orientation.
+	input = insertSegment(data, exifSegment(binary.LittleEndian, 6, thumb))
+	m, err = DecodeEmbeddedThumbnail(bytes.NewReader(input), DecodeOptions{DCTSizeScaled: 4, AutoOrient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalImages(m, orient(want, 6)); err != nil {
+		t.Errorf("orientation 6: %v", err)
+	}
+
+	// The image's own frame is not parsed: an unsupported precision, cut
+	// short, does not hide the thumbnail.
+	input = insertSegment(data, seg)
+	sof := 2 + len(seg) + bytes.Index(data[2:], []byte{0xff, sof0Marker})
+	input = append(input[:sof+4:sof+4], 7, 0, 1)
+	if _, err := DecodeConfig(bytes.NewReader(input)); err == nil {
+		t.Error("unsupported frame: DecodeConfig succeeded")
+	}
+	m, err = DecodeEmbeddedThumbnail(bytes.NewReader(input), opts)
+	if err != nil {
+		t.Fatalf("unsupported frame: %v", err)
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Errorf("unsupported frame: %v", err)
+	}
+
+	input = insertSegment(data, exifSegment(binary.LittleEndian, 1, nil))
+	if _, err := DecodeEmbeddedThumbnail(bytes.NewReader(input), opts); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("without thumbnail: got %v, want %v", err, ErrNoThumbnail)
+	}
+}
@@ -0,0 +1,60 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// removeSegments returns data without the marker segments before the first
+// scan whose marker is marker.
+func removeSegments(data []byte, marker byte) []byte {
+	out := append([]byte(nil), data[:2]...)
+	for i := 2; i+4 <= len(data); {
+		m, n := data[i+1], int(data[i+2])<<8|int(data[i+3])
+		if m == sosMarker {
+			return append(out, data[i:]...)
+		}
+		if m != marker {
+			out = append(out, data[i:i+2+n]...)
+		}
+		i += 2 + n
+	}
+	return out
+}
+
+// TestDecodeStandardHuffman tests that images without DHT segments, as
+// Motion-JPEG frames are, decode with the tables of section K.3, the same
+// as images that define those tables.
+func TestDecodeStandardHuffman(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		opts     DecodeOptions
+	}{
+		{"testdata/video-001.q50.420.jpeg", DecodeOptions{}},
+		{"testdata/video-001.q50.420.jpeg", DecodeOptions{DCTSizeScaled: 2}},
+		{"testdata/video-005.gray.q50.jpeg", DecodeOptions{}},
+		{"testdata/video-001.rst3.jpeg", DecodeOptions{Concurrency: 4}},
+	} {
+		data, err := os.ReadFile(tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := Decode(bytes.NewReader(data), tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stripped := removeSegments(data, dhtMarker)
+		if len(stripped) == len(data) {
+			t.Fatalf("%s: no DHT segment to remove", tc.filename)
+		}
+		got, err := Decode(bytes.NewReader(stripped), tc.opts)
+		if err != nil {
+			t.Errorf("%s %+v: %v", tc.filename, tc.opts, err)
+			continue
+		}
+		if err := equalImages(got, want); err != nil {
+			t.Errorf("%s %+v: %v", tc.filename, tc.opts, err)
+		}
+	}
+}
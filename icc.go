@@ -0,0 +1,55 @@
+package jpegscaled
+
+// iccHeader starts the payload of an APP2 segment that holds a chunk of an
+// ICC profile, as specified in the ICC specification, section B.4. It is
+// followed by the chunk's 1-based sequence number and the number of chunks.
+const iccHeader = "ICC_PROFILE\x00"
+
+// processApp2Marker reads an APP2 segment. If it holds a chunk of an ICC
+// profile, the chunk is kept in d.iccChunks. Other APP2 segments are ignored.
+func (d *decoder) processApp2Marker(n int) error {
+	if n < len(iccHeader)+2 {
+		return d.ignore(n)
+	}
+	if err := d.readFull(d.tmp[:len(iccHeader)+2]); err != nil {
+		return err
+	}
+	n -= len(iccHeader) + 2
+	if string(d.tmp[:len(iccHeader)]) != iccHeader {
+		return d.ignore(n)
+	}
+	seq, count := int(d.tmp[len(iccHeader)]), int(d.tmp[len(iccHeader)+1])
+	chunk := make([]byte, n)
+	if err := d.readFull(chunk); err != nil {
+		return err
+	}
+	if d.iccChunks == nil {
+		d.iccChunks = make([][]byte, count)
+	}
+	if seq == 0 || seq > len(d.iccChunks) || count != len(d.iccChunks) || d.iccChunks[seq-1] != nil {
+		d.iccInvalid = true
+		return nil
+	}
+	d.iccChunks[seq-1] = chunk
+	return nil
+}
+
+// iccProfile returns the ICC profile reassembled from d.iccChunks, or nil if
+// there is none, or if chunks are missing or inconsistent.
+func (d *decoder) iccProfile() []byte {
+	if d.iccInvalid || len(d.iccChunks) == 0 {
+		return nil
+	}
+	n := 0
+	for _, c := range d.iccChunks {
+		if c == nil {
+			return nil
+		}
+		n += len(c)
+	}
+	profile := make([]byte, 0, n)
+	for _, c := range d.iccChunks {
+		profile = append(profile, c...)
+	}
+	return profile
+}
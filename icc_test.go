@@ -0,0 +1,85 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+// iccSegment returns an APP2 segment holding chunk seq of count of an ICC
+// profile.
+func iccSegment(chunk []byte, seq, count byte) []byte {
+	payload := append([]byte(iccHeader), seq, count)
+	payload = append(payload, chunk...)
+	seg := []byte{0xff, app2Marker}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func TestICCProfile(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := []byte("0123456789abcdefghij")
+	a, b, c := profile[:7], profile[7:15], profile[15:]
+	for _, tc := range []struct {
+		desc     string
+		segments [][]byte
+		want     []byte
+	}{
+		{"none", nil, nil},
+		{"single", [][]byte{iccSegment(profile, 1, 1)}, profile},
+		{"in order", [][]byte{iccSegment(a, 1, 3), iccSegment(b, 2, 3), iccSegment(c, 3, 3)}, profile},
+		{"out of order", [][]byte{iccSegment(c, 3, 3), iccSegment(a, 1, 3), iccSegment(b, 2, 3)}, profile},
+		{"missing chunk", [][]byte{iccSegment(a, 1, 3), iccSegment(c, 3, 3)}, nil},
+		{"duplicate chunk", [][]byte{iccSegment(a, 1, 2), iccSegment(a, 1, 2), iccSegment(b, 2, 2)}, nil},
+		{"inconsistent count", [][]byte{iccSegment(a, 1, 2), iccSegment(b, 2, 3)}, nil},
+		{"zero sequence number", [][]byte{iccSegment(profile, 0, 1)}, nil},
+	} {
+		input := data
+		for i := len(tc.segments) - 1; i >= 0; i-- {
+			input = insertSegment(input, tc.segments[i])
+		}
+		cfg, err := DecodeConfig(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		if !bytes.Equal(cfg.ICCProfile, tc.want) {
+			t.Errorf("%s: got profile %q, want %q", tc.desc, cfg.ICCProfile, tc.want)
+		}
+	}
+}
+
+// TestDecodeResult tests that DecodeResult returns the image that Decode
+// does and the Config that DecodeConfig does.
+func TestDecodeResult(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.progressive.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = insertSegment(data, iccSegment([]byte("profile"), 1, 1))
+	data = insertSegment(data, exifSegment(binary.LittleEndian, 6, nil))
+	opts := DecodeOptions{DCTSizeScaled: 3}
+	want, err := Decode(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := DecodeResult(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalImages(res.Image, want); err != nil {
+		t.Error(err)
+	}
+	cfg := res.Config
+	if cfg.Width != wantCfg.Width || cfg.Height != wantCfg.Height || cfg.ColorModel != wantCfg.ColorModel ||
+		cfg.JpegType != wantCfg.JpegType || cfg.Orientation != 6 || string(cfg.ICCProfile) != "profile" {
+		t.Errorf("got config %+v, want %+v", cfg, wantCfg)
+	}
+}
@@ -0,0 +1,1032 @@
+package jpegscaled
+
+// The 1-D kernels below are also translated from jidctint.c; see idct.go
+// for its notice.
+
+// MAXJSAMPLE12 and CENTERJSAMPLE12 are MAXJSAMPLE and CENTERJSAMPLE for
+// 12-bit images.
+const (
+	MAXJSAMPLE12    = 4095
+	CENTERJSAMPLE12 = 2048
+)
+
+// PASS1_BITS12 is PASS1_BITS for 12-bit images: jidctint.c loses a little
+// precision there to avoid overflow.
+const PASS1_BITS12 = 1
+
+// maxDCTSize is the largest output block size produced from one 8x8 input
+// DCT block, i.e. a 2x enlargement.
+const maxDCTSize = 16
+
+/*
+ * Perform dequantization and inverse DCT on one block of coefficients,
+ * producing an outW x outH output block in out, with a row stride of outW.
+ *
+ * This runs the 1-D kernels of the jpeg_idct_NxN routines of jidctint.c,
+ * the outH-point kernel on the columns and the outW-point kernel on the
+ * rows, so square outputs are those of jpeg_idct_NxN, and rectangular
+ * ones (16x8, 8x16, 8x4, ...) are those of the routines that combine two
+ * kernels in the same way, such as jpeg_idct_16x8.  It handles the sizes
+ * that do not have a routine in idct.go: those above 8, the rectangular
+ * ones used when the horizontal and vertical scale factors differ, and
+ * 12-bit samples.
+ *
+ * precision is the sample precision, 8 or 12 bits. 8-bit samples are range
+ * limited by range_limit, and 12-bit samples are clamped to
+ * [0, MAXJSAMPLE12].
+ */
+func jpeg_idct_scaled(src, qt *block, out []int32, outW, outH, precision int) {
+	var workspace [maxDCTSize * DCTSIZE]int32 /* buffers data between passes */
+	var in [DCTSIZE]int32
+	var res [maxDCTSize]int32
+
+	pass1Bits, center := int32(PASS1_BITS), int32(RANGE_CENTER)
+	if precision != 8 {
+		pass1Bits, center = PASS1_BITS12, CENTERJSAMPLE12
+	}
+	kw, kh := min(outW, DCTSIZE), min(outH, DCTSIZE)
+	colIDCT, rowIDCT := idct_1d[outH], idct_1d[outW]
+
+	/* Pass 1: process columns from input, store into work array. */
+
+	for ctr := 0; ctr < kw; ctr++ {
+		for k := 0; k < kh; k++ {
+			in[k] = DEQUANTIZE(src[DCTSIZE*k+ctr], qt[jpegZigzagOrder[DCTSIZE*k+ctr]])
+		}
+		in[0] <<= CONST_BITS
+		/* Add fudge factor here for final descale. */
+		in[0] += ONE << (CONST_BITS - pass1Bits - 1)
+
+		colIDCT(&in, &res)
+
+		for y := 0; y < outH; y++ {
+			workspace[DCTSIZE*y+ctr] = RIGHT_SHIFT(res[y], CONST_BITS-pass1Bits)
+		}
+	}
+
+	/* Pass 2: process rows from work array, store into output array. */
+
+	for ctr := 0; ctr < outH; ctr++ {
+		copy(in[:kw], workspace[DCTSIZE*ctr:])
+		/* Add range center and fudge factor for final descale and range-limit. */
+		in[0] += (center << (pass1Bits + 3)) + (ONE << (pass1Bits + 2))
+		in[0] <<= CONST_BITS
+
+		rowIDCT(&in, &res)
+
+		outptr := out[ctr*outW:]
+		for x := 0; x < outW; x++ {
+			v := RIGHT_SHIFT(res[x], CONST_BITS+pass1Bits+3)
+			if precision == 8 {
+				outptr[x] = range_limit(v)
+			} else {
+				outptr[x] = min(max(v, 0), MAXJSAMPLE12)
+			}
+		}
+	}
+}
+
+/*
+ * The 1-D kernels compute the n-point IDCT of the min(n, 8) values in in,
+ * for n = 1...16, into out[0...n-1], with the same operations as both
+ * passes of jpeg_idct_NxN.  in[0] is the DC term scaled by CONST_BITS,
+ * with the fudge factor, and range center, of the pass already added;
+ * the outputs are not descaled.  For N>8, the higher frequencies are zero.
+ */
+var idct_1d = [maxDCTSize + 1]func(in *[DCTSIZE]int32, out *[maxDCTSize]int32){
+	nil, idct_1d_1, idct_1d_2, idct_1d_3, idct_1d_4,
+	idct_1d_5, idct_1d_6, idct_1d_7, idct_1d_8,
+	idct_1d_9, idct_1d_10, idct_1d_11, idct_1d_12,
+	idct_1d_13, idct_1d_14, idct_1d_15, idct_1d_16,
+}
+
+/* 1-point IDCT: the DC term. */
+func idct_1d_1(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	out[0] = in[0]
+}
+
+/* 2-point IDCT, multiplication-less. */
+func idct_1d_2(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	tmp0 := in[0]
+	tmp1 := in[1] << CONST_BITS
+
+	out[0] = tmp0 + tmp1
+	out[1] = tmp0 - tmp1
+}
+
+/*
+ * 3-point IDCT with 2 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/6).
+ */
+func idct_1d_3(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp2, tmp10, tmp12 int32
+
+	/* Even part */
+
+	tmp0 = in[0]
+	tmp2 = in[2]
+	tmp12 = MULTIPLY(tmp2, FIX(0.707106781)) /* c2 */
+	tmp10 = tmp0 + tmp12
+	tmp2 = tmp0 - tmp12 - tmp12
+
+	/* Odd part */
+
+	tmp12 = in[1]
+	tmp0 = MULTIPLY(tmp12, FIX(1.224744871)) /* c1 */
+
+	/* Final output stage */
+
+	out[0] = tmp10 + tmp0
+	out[2] = tmp10 - tmp0
+	out[1] = tmp2
+}
+
+/*
+ * 4-point IDCT with 3 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/16) [refers to 8-point IDCT].
+ */
+func idct_1d_4(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp2, tmp10, tmp12 int32
+	var z1, z2, z3 int32
+
+	/* Even part */
+
+	tmp0 = in[0]
+	tmp2 = in[2] << CONST_BITS
+
+	tmp10 = tmp0 + tmp2
+	tmp12 = tmp0 - tmp2
+
+	/* Odd part */
+	/* Same rotation as in the even part of the 8x8 LL&M IDCT */
+
+	z2 = in[1]
+	z3 = in[3]
+
+	z1 = MULTIPLY(z2+z3, FIX_0_541196100)     /* c6 */
+	tmp0 = z1 + MULTIPLY(z2, FIX_0_765366865) /* c2-c6 */
+	tmp2 = z1 - MULTIPLY(z3, FIX_1_847759065) /* c2+c6 */
+
+	/* Final output stage */
+
+	out[0] = tmp10 + tmp0
+	out[3] = tmp10 - tmp0
+	out[1] = tmp12 + tmp2
+	out[2] = tmp12 - tmp2
+}
+
+/*
+ * 5-point IDCT with 5 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/10).
+ */
+func idct_1d_5(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp1, tmp10, tmp11, tmp12 int32
+	var z1, z2, z3 int32
+
+	/* Even part */
+
+	tmp12 = in[0]
+	tmp0 = in[2]
+	tmp1 = in[4]
+	z1 = MULTIPLY(tmp0+tmp1, FIX(0.790569415)) /* (c2+c4)/2 */
+	z2 = MULTIPLY(tmp0-tmp1, FIX(0.353553391)) /* (c2-c4)/2 */
+	z3 = tmp12 + z2
+	tmp10 = z3 + z1
+	tmp11 = z3 - z1
+	tmp12 -= z2 << 2
+
+	/* Odd part */
+
+	z2 = in[1]
+	z3 = in[3]
+
+	z1 = MULTIPLY(z2+z3, FIX(0.831253876))     /* c3 */
+	tmp0 = z1 + MULTIPLY(z2, FIX(0.513743148)) /* c1-c3 */
+	tmp1 = z1 - MULTIPLY(z3, FIX(2.176250899)) /* c1+c3 */
+
+	/* Final output stage */
+
+	out[0] = tmp10 + tmp0
+	out[4] = tmp10 - tmp0
+	out[1] = tmp11 + tmp1
+	out[3] = tmp11 - tmp1
+	out[2] = tmp12
+}
+
+/*
+ * 6-point IDCT with 3 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/12).
+ */
+func idct_1d_6(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp1, tmp2, tmp10, tmp11, tmp12 int32
+	var z1, z2, z3 int32
+
+	/* Even part */
+
+	tmp0 = in[0]
+	tmp2 = in[4]
+	tmp10 = MULTIPLY(tmp2, FIX(0.707106781)) /* c4 */
+	tmp1 = tmp0 + tmp10
+	tmp11 = tmp0 - tmp10 - tmp10
+	tmp10 = in[2]
+	tmp0 = MULTIPLY(tmp10, FIX(1.224744871)) /* c2 */
+	tmp10 = tmp1 + tmp0
+	tmp12 = tmp1 - tmp0
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	tmp1 = MULTIPLY(z1+z3, FIX(0.366025404)) /* c5 */
+	tmp0 = tmp1 + ((z1 + z2) << CONST_BITS)
+	tmp2 = tmp1 + ((z3 - z2) << CONST_BITS)
+	tmp1 = (z1 - z2 - z3) << CONST_BITS
+
+	/* Final output stage */
+
+	out[0] = tmp10 + tmp0
+	out[5] = tmp10 - tmp0
+	out[1] = tmp11 + tmp1
+	out[4] = tmp11 - tmp1
+	out[2] = tmp12 + tmp2
+	out[3] = tmp12 - tmp2
+}
+
+/*
+ * 7-point IDCT with 12 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/14).
+ */
+func idct_1d_7(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp1, tmp2, tmp10, tmp11, tmp12, tmp13 int32
+	var z1, z2, z3 int32
+
+	/* Even part */
+
+	tmp13 = in[0]
+
+	z1 = in[2]
+	z2 = in[4]
+	z3 = in[6]
+
+	tmp10 = MULTIPLY(z2-z3, FIX(0.881747734))                      /* c4 */
+	tmp12 = MULTIPLY(z1-z2, FIX(0.314692123))                      /* c6 */
+	tmp11 = tmp10 + tmp12 + tmp13 - MULTIPLY(z2, FIX(1.841218003)) /* c2+c4-c6 */
+	tmp0 = z1 + z3
+	z2 -= tmp0
+	tmp0 = MULTIPLY(tmp0, FIX(1.274162392)) + tmp13 /* c2 */
+	tmp10 += tmp0 - MULTIPLY(z3, FIX(0.077722536))  /* c2-c4-c6 */
+	tmp12 += tmp0 - MULTIPLY(z1, FIX(2.470602249))  /* c2+c4+c6 */
+	tmp13 += MULTIPLY(z2, FIX(1.414213562))         /* c0 */
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+
+	tmp1 = MULTIPLY(z1+z2, FIX(0.935414347)) /* (c3+c1-c5)/2 */
+	tmp2 = MULTIPLY(z1-z2, FIX(0.170262339)) /* (c3+c5-c1)/2 */
+	tmp0 = tmp1 - tmp2
+	tmp1 += tmp2
+	tmp2 = MULTIPLY(z2+z3, -FIX(1.378756276)) /* -c1 */
+	tmp1 += tmp2
+	z2 = MULTIPLY(z1+z3, FIX(0.613604268)) /* c5 */
+	tmp0 += z2
+	tmp2 += z2 + MULTIPLY(z3, FIX(1.870828693)) /* c3+c1-c5 */
+
+	/* Final output stage */
+
+	out[0] = tmp10 + tmp0
+	out[6] = tmp10 - tmp0
+	out[1] = tmp11 + tmp1
+	out[5] = tmp11 - tmp1
+	out[2] = tmp12 + tmp2
+	out[4] = tmp12 - tmp2
+	out[3] = tmp13
+}
+
+/*
+ * 8-point IDCT with 12 multiplications, as in idct_slow.
+ * cK represents sqrt(2) * cos(K*pi/16).
+ */
+func idct_1d_8(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp1, tmp2, tmp3 int32
+	var tmp10, tmp11, tmp12, tmp13 int32
+	var z1, z2, z3 int32
+
+	/* Even part: reverse the even part of the forward DCT.
+	 * The rotator is c(-6).
+	 */
+
+	z2 = in[0]
+	z3 = in[4] << CONST_BITS
+
+	tmp0 = z2 + z3
+	tmp1 = z2 - z3
+
+	z2 = in[2]
+	z3 = in[6]
+
+	z1 = MULTIPLY(z2+z3, FIX_0_541196100)     /* c6 */
+	tmp2 = z1 + MULTIPLY(z2, FIX_0_765366865) /* c2-c6 */
+	tmp3 = z1 - MULTIPLY(z3, FIX_1_847759065) /* c2+c6 */
+
+	tmp10 = tmp0 + tmp2
+	tmp13 = tmp0 - tmp2
+	tmp11 = tmp1 + tmp3
+	tmp12 = tmp1 - tmp3
+
+	/* Odd part per figure 8; the matrix is unitary and hence its
+	 * transpose is its inverse.  i0..i3 are y7,y5,y3,y1 respectively.
+	 */
+
+	tmp0 = in[7]
+	tmp1 = in[5]
+	tmp2 = in[3]
+	tmp3 = in[1]
+
+	z2 = tmp0 + tmp2
+	z3 = tmp1 + tmp3
+
+	z1 = MULTIPLY(z2+z3, FIX_1_175875602) /*  c3 */
+	z2 = MULTIPLY(z2, -FIX_1_961570560)   /* -c3-c5 */
+	z3 = MULTIPLY(z3, -FIX_0_390180644)   /* -c3+c5 */
+	z2 += z1
+	z3 += z1
+
+	z1 = MULTIPLY(tmp0+tmp3, -FIX_0_899976223) /* -c3+c7 */
+	tmp0 = MULTIPLY(tmp0, FIX_0_298631336)     /* -c1+c3+c5-c7 */
+	tmp3 = MULTIPLY(tmp3, FIX_1_501321110)     /*  c1+c3-c5-c7 */
+	tmp0 += z1 + z2
+	tmp3 += z1 + z3
+
+	z1 = MULTIPLY(tmp1+tmp2, -FIX_2_562915447) /* -c1-c3 */
+	tmp1 = MULTIPLY(tmp1, FIX_2_053119869)     /*  c1+c3-c5+c7 */
+	tmp2 = MULTIPLY(tmp2, FIX_3_072711026)     /*  c1+c3+c5-c7 */
+	tmp1 += z1 + z3
+	tmp2 += z1 + z2
+
+	/* Final output stage: inputs are tmp10..tmp13, tmp0..tmp3 */
+
+	out[0] = tmp10 + tmp3
+	out[7] = tmp10 - tmp3
+	out[1] = tmp11 + tmp2
+	out[6] = tmp11 - tmp2
+	out[2] = tmp12 + tmp1
+	out[5] = tmp12 - tmp1
+	out[3] = tmp13 + tmp0
+	out[4] = tmp13 - tmp0
+}
+
+/*
+ * 9-point IDCT with 10 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/18).
+ */
+func idct_1d_9(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp1, tmp2, tmp3, tmp10, tmp11, tmp12, tmp13, tmp14 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	tmp0 = in[0]
+
+	z1 = in[2]
+	z2 = in[4]
+	z3 = in[6]
+
+	tmp3 = MULTIPLY(z3, FIX(0.707106781)) /* c6 */
+	tmp1 = tmp0 + tmp3
+	tmp2 = tmp0 - tmp3 - tmp3
+
+	tmp0 = MULTIPLY(z1-z2, FIX(0.707106781)) /* c6 */
+	tmp11 = tmp2 + tmp0
+	tmp14 = tmp2 - tmp0 - tmp0
+
+	tmp0 = MULTIPLY(z1+z2, FIX(1.328926049)) /* c2 */
+	tmp2 = MULTIPLY(z1, FIX(1.083350441))    /* c4 */
+	tmp3 = MULTIPLY(z2, FIX(0.245575608))    /* c8 */
+
+	tmp10 = tmp1 + tmp0 - tmp3
+	tmp12 = tmp1 - tmp0 + tmp2
+	tmp13 = tmp1 - tmp2 + tmp3
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+
+	z2 = MULTIPLY(z2, -FIX(1.224744871)) /* -c3 */
+
+	tmp2 = MULTIPLY(z1+z3, FIX(0.909038955)) /* c5 */
+	tmp3 = MULTIPLY(z1+z4, FIX(0.483689525)) /* c7 */
+	tmp0 = tmp2 + tmp3 - z2
+	tmp1 = MULTIPLY(z3-z4, FIX(1.392728481)) /* c1 */
+	tmp2 += z2 - tmp1
+	tmp3 += z2 + tmp1
+	tmp1 = MULTIPLY(z1-z3-z4, FIX(1.224744871)) /* c3 */
+
+	/* Final output stage */
+
+	out[0] = tmp10 + tmp0
+	out[8] = tmp10 - tmp0
+	out[1] = tmp11 + tmp1
+	out[7] = tmp11 - tmp1
+	out[2] = tmp12 + tmp2
+	out[6] = tmp12 - tmp2
+	out[3] = tmp13 + tmp3
+	out[5] = tmp13 - tmp3
+	out[4] = tmp14
+}
+
+/*
+ * 10-point IDCT with 12 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/20).
+ */
+func idct_1d_10(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp10, tmp11, tmp12, tmp13, tmp14 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24 int32
+	var z1, z2, z3, z4, z5 int32
+
+	/* Even part */
+
+	z3 = in[0]
+	z4 = in[4]
+	z1 = MULTIPLY(z4, FIX(1.144122806)) /* c4 */
+	z2 = MULTIPLY(z4, FIX(0.437016024)) /* c8 */
+	tmp10 = z3 + z1
+	tmp11 = z3 - z2
+
+	tmp22 = z3 - ((z1 - z2) << 1) /* c0 = (c4-c8)*2 */
+
+	z2 = in[2]
+	z3 = in[6]
+
+	z1 = MULTIPLY(z2+z3, FIX(0.831253876))      /* c6 */
+	tmp12 = z1 + MULTIPLY(z2, FIX(0.513743148)) /* c2-c6 */
+	tmp13 = z1 - MULTIPLY(z3, FIX(2.176250899)) /* c2+c6 */
+
+	tmp20 = tmp10 + tmp12
+	tmp24 = tmp10 - tmp12
+	tmp21 = tmp11 + tmp13
+	tmp23 = tmp11 - tmp13
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+
+	tmp11 = z2 + z4
+	tmp13 = z2 - z4
+
+	tmp12 = MULTIPLY(tmp13, FIX(0.309016994)) /* (c3-c7)/2 */
+	z5 = z3 << CONST_BITS
+
+	z2 = MULTIPLY(tmp11, FIX(0.951056516)) /* (c3+c7)/2 */
+	z4 = z5 + tmp12
+
+	tmp10 = MULTIPLY(z1, FIX(1.396802247)) + z2 + z4 /* c1 */
+	tmp14 = MULTIPLY(z1, FIX(0.221231742)) - z2 + z4 /* c9 */
+
+	z2 = MULTIPLY(tmp11, FIX(0.587785252)) /* (c1-c9)/2 */
+	z4 = z5 - tmp12 - (tmp13 << (CONST_BITS - 1))
+
+	tmp12 = ((z1 - tmp13) << CONST_BITS) - z5
+
+	tmp11 = MULTIPLY(z1, FIX(1.260073511)) - z2 - z4 /* c3 */
+	tmp13 = MULTIPLY(z1, FIX(0.642039522)) - z2 + z4 /* c7 */
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp10
+	out[9] = tmp20 - tmp10
+	out[1] = tmp21 + tmp11
+	out[8] = tmp21 - tmp11
+	out[2] = tmp22 + tmp12
+	out[7] = tmp22 - tmp12
+	out[3] = tmp23 + tmp13
+	out[6] = tmp23 - tmp13
+	out[4] = tmp24 + tmp14
+	out[5] = tmp24 - tmp14
+}
+
+/*
+ * 11-point IDCT with 24 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/22).
+ */
+func idct_1d_11(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp10, tmp11, tmp12, tmp13, tmp14 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24, tmp25 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	tmp10 = in[0]
+
+	z1 = in[2]
+	z2 = in[4]
+	z3 = in[6]
+
+	tmp20 = MULTIPLY(z2-z3, FIX(2.546640132)) /* c2+c4 */
+	tmp23 = MULTIPLY(z2-z1, FIX(0.430815045)) /* c2-c6 */
+	z4 = z1 + z3
+	tmp24 = MULTIPLY(z4, -FIX(1.155664402)) /* -(c2-c10) */
+	z4 -= z2
+	tmp25 = tmp10 + MULTIPLY(z4, FIX(1.356927976)) /* c2 */
+	tmp21 = tmp20 + tmp23 + tmp25 -
+		MULTIPLY(z2, FIX(1.821790775)) /* c2+c4+c10-c6 */
+	tmp20 += tmp25 + MULTIPLY(z3, FIX(2.115825087)) /* c4+c6 */
+	tmp23 += tmp25 - MULTIPLY(z1, FIX(1.513598477)) /* c6+c8 */
+	tmp24 += tmp25
+	tmp22 = tmp24 - MULTIPLY(z3, FIX(0.788749120)) /* c8+c10 */
+	tmp24 += MULTIPLY(z2, FIX(1.944413522)) -      /* c2+c8 */
+		MULTIPLY(z1, FIX(1.390975730)) /* c4+c10 */
+	tmp25 = tmp10 - MULTIPLY(z4, FIX(1.414213562)) /* c0 */
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+
+	tmp11 = z1 + z2
+	tmp14 = MULTIPLY(tmp11+z3+z4, FIX(0.398430003))   /* c9 */
+	tmp11 = MULTIPLY(tmp11, FIX(0.887983902))         /* c3-c9 */
+	tmp12 = MULTIPLY(z1+z3, FIX(0.670361295))         /* c5-c9 */
+	tmp13 = tmp14 + MULTIPLY(z1+z4, FIX(0.366151574)) /* c7-c9 */
+	tmp10 = tmp11 + tmp12 + tmp13 -
+		MULTIPLY(z1, FIX(0.923107866)) /* c7+c5+c3-c1-2*c9 */
+	z1 = tmp14 - MULTIPLY(z2+z3, FIX(1.163011579)) /* c7+c9 */
+	tmp11 += z1 + MULTIPLY(z2, FIX(2.073276588))   /* c1+c7+3*c9-c3 */
+	tmp12 += z1 - MULTIPLY(z3, FIX(1.192193623))   /* c3+c5-c7-c9 */
+	z1 = MULTIPLY(z2+z4, -FIX(1.798248910))        /* -(c1+c9) */
+	tmp11 += z1
+	tmp13 += z1 + MULTIPLY(z4, FIX(2.102458632)) /* c1+c5+c9-c7 */
+	tmp14 += MULTIPLY(z2, -FIX(1.467221301)) +   /* -(c5+c9) */
+		MULTIPLY(z3, FIX(1.001388905)) - /* c1-c9 */
+		MULTIPLY(z4, FIX(1.684843907)) /* c3+c9 */
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp10
+	out[10] = tmp20 - tmp10
+	out[1] = tmp21 + tmp11
+	out[9] = tmp21 - tmp11
+	out[2] = tmp22 + tmp12
+	out[8] = tmp22 - tmp12
+	out[3] = tmp23 + tmp13
+	out[7] = tmp23 - tmp13
+	out[4] = tmp24 + tmp14
+	out[6] = tmp24 - tmp14
+	out[5] = tmp25
+}
+
+/*
+ * 12-point IDCT with 15 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/24).
+ */
+func idct_1d_12(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp10, tmp11, tmp12, tmp13, tmp14, tmp15 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24, tmp25 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	z3 = in[0]
+	z4 = in[4]
+	z4 = MULTIPLY(z4, FIX(1.224744871)) /* c4 */
+
+	tmp10 = z3 + z4
+	tmp11 = z3 - z4
+
+	z1 = in[2]
+	z4 = MULTIPLY(z1, FIX(1.366025404)) /* c2 */
+	z1 <<= CONST_BITS
+	z2 = in[6]
+	z2 <<= CONST_BITS
+
+	tmp12 = z1 - z2
+
+	tmp21 = z3 + tmp12
+	tmp24 = z3 - tmp12
+
+	tmp12 = z4 + z2
+
+	tmp20 = tmp10 + tmp12
+	tmp25 = tmp10 - tmp12
+
+	tmp12 = z4 - z1 - z2
+
+	tmp22 = tmp11 + tmp12
+	tmp23 = tmp11 - tmp12
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+
+	tmp11 = MULTIPLY(z2, FIX(1.306562965)) /* c3 */
+	tmp14 = MULTIPLY(z2, -FIX_0_541196100) /* -c9 */
+
+	tmp10 = z1 + z3
+	tmp15 = MULTIPLY(tmp10+z4, FIX(0.860918669))            /* c7 */
+	tmp12 = tmp15 + MULTIPLY(tmp10, FIX(0.261052384))       /* c5-c7 */
+	tmp10 = tmp12 + tmp11 + MULTIPLY(z1, FIX(0.280143716))  /* c1-c5 */
+	tmp13 = MULTIPLY(z3+z4, -FIX(1.045510580))              /* -(c7+c11) */
+	tmp12 += tmp13 + tmp14 - MULTIPLY(z3, FIX(1.478575242)) /* c1+c5-c7-c11 */
+	tmp13 += tmp15 - tmp11 + MULTIPLY(z4, FIX(1.586706681)) /* c1+c11 */
+	tmp15 += tmp14 - MULTIPLY(z1, FIX(0.676326758)) -       /* c7-c11 */
+		MULTIPLY(z4, FIX(1.982889723)) /* c5+c7 */
+
+	z1 -= z4
+	z2 -= z3
+	z3 = MULTIPLY(z1+z2, FIX_0_541196100)      /* c9 */
+	tmp11 = z3 + MULTIPLY(z1, FIX_0_765366865) /* c3-c9 */
+	tmp14 = z3 - MULTIPLY(z2, FIX_1_847759065) /* c3+c9 */
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp10
+	out[11] = tmp20 - tmp10
+	out[1] = tmp21 + tmp11
+	out[10] = tmp21 - tmp11
+	out[2] = tmp22 + tmp12
+	out[9] = tmp22 - tmp12
+	out[3] = tmp23 + tmp13
+	out[8] = tmp23 - tmp13
+	out[4] = tmp24 + tmp14
+	out[7] = tmp24 - tmp14
+	out[5] = tmp25 + tmp15
+	out[6] = tmp25 - tmp15
+}
+
+/*
+ * 13-point IDCT with 29 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/26).
+ */
+func idct_1d_13(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp10, tmp11, tmp12, tmp13, tmp14, tmp15 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24, tmp25, tmp26 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	z1 = in[0]
+
+	z2 = in[2]
+	z3 = in[4]
+	z4 = in[6]
+
+	tmp10 = z3 + z4
+	tmp11 = z3 - z4
+
+	tmp12 = MULTIPLY(tmp10, FIX(1.155388986))      /* (c4+c6)/2 */
+	tmp13 = MULTIPLY(tmp11, FIX(0.096834934)) + z1 /* (c4-c6)/2 */
+
+	tmp20 = MULTIPLY(z2, FIX(1.373119086)) + tmp12 + tmp13 /* c2 */
+	tmp22 = MULTIPLY(z2, FIX(0.501487041)) - tmp12 + tmp13 /* c10 */
+
+	tmp12 = MULTIPLY(tmp10, FIX(0.316450131))      /* (c8-c12)/2 */
+	tmp13 = MULTIPLY(tmp11, FIX(0.486914739)) + z1 /* (c8+c12)/2 */
+
+	tmp21 = MULTIPLY(z2, FIX(1.058554052)) - tmp12 + tmp13  /* c6 */
+	tmp25 = MULTIPLY(z2, -FIX(1.252223920)) + tmp12 + tmp13 /* c4 */
+
+	tmp12 = MULTIPLY(tmp10, FIX(0.435816023))      /* (c2-c10)/2 */
+	tmp13 = MULTIPLY(tmp11, FIX(0.937303064)) - z1 /* (c2+c10)/2 */
+
+	tmp23 = MULTIPLY(z2, -FIX(0.170464608)) - tmp12 - tmp13 /* c12 */
+	tmp24 = MULTIPLY(z2, -FIX(0.803364869)) + tmp12 - tmp13 /* c8 */
+
+	tmp26 = MULTIPLY(tmp11-z2, FIX(1.414213562)) + z1 /* c0 */
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+
+	tmp11 = MULTIPLY(z1+z2, FIX(1.322312651)) /* c3 */
+	tmp12 = MULTIPLY(z1+z3, FIX(1.163874945)) /* c5 */
+	tmp15 = z1 + z4
+	tmp13 = MULTIPLY(tmp15, FIX(0.937797057)) /* c7 */
+	tmp10 = tmp11 + tmp12 + tmp13 -
+		MULTIPLY(z1, FIX(2.020082300)) /* c7+c5+c3-c1 */
+	tmp14 = MULTIPLY(z2+z3, -FIX(0.338443458))      /* -c11 */
+	tmp11 += tmp14 + MULTIPLY(z2, FIX(0.837223564)) /* c5+c9+c11-c3 */
+	tmp12 += tmp14 - MULTIPLY(z3, FIX(1.572116027)) /* c1+c5-c9-c11 */
+	tmp14 = MULTIPLY(z2+z4, -FIX(1.163874945))      /* -c5 */
+	tmp11 += tmp14
+	tmp13 += tmp14 + MULTIPLY(z4, FIX(2.205608352)) /* c3+c5+c9-c7 */
+	tmp14 = MULTIPLY(z3+z4, -FIX(0.657217813))      /* -c9 */
+	tmp12 += tmp14
+	tmp13 += tmp14
+	tmp15 = MULTIPLY(tmp15, FIX(0.338443458))        /* c11 */
+	tmp14 = tmp15 + MULTIPLY(z1, FIX(0.318774355)) - /* c9-c11 */
+		MULTIPLY(z2, FIX(0.466105296)) /* c1-c7 */
+	z1 = MULTIPLY(z3-z2, FIX(0.937797057)) /* c7 */
+	tmp14 += z1
+	tmp15 += z1 + MULTIPLY(z3, FIX(0.384515595)) - /* c3-c7 */
+		MULTIPLY(z4, FIX(1.742345811)) /* c1+c11 */
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp10
+	out[12] = tmp20 - tmp10
+	out[1] = tmp21 + tmp11
+	out[11] = tmp21 - tmp11
+	out[2] = tmp22 + tmp12
+	out[10] = tmp22 - tmp12
+	out[3] = tmp23 + tmp13
+	out[9] = tmp23 - tmp13
+	out[4] = tmp24 + tmp14
+	out[8] = tmp24 - tmp14
+	out[5] = tmp25 + tmp15
+	out[7] = tmp25 - tmp15
+	out[6] = tmp26
+}
+
+/*
+ * 14-point IDCT with 20 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/28).
+ */
+func idct_1d_14(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp10, tmp11, tmp12, tmp13, tmp14, tmp15, tmp16 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24, tmp25, tmp26 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	z1 = in[0]
+	z4 = in[4]
+	z2 = MULTIPLY(z4, FIX(1.274162392)) /* c4 */
+	z3 = MULTIPLY(z4, FIX(0.314692123)) /* c12 */
+	z4 = MULTIPLY(z4, FIX(0.881747734)) /* c8 */
+
+	tmp10 = z1 + z2
+	tmp11 = z1 + z3
+	tmp12 = z1 - z4
+
+	tmp23 = z1 - ((z2 + z3 - z4) << 1) /* c0 = (c4+c12-c8)*2 */
+
+	z1 = in[2]
+	z2 = in[6]
+
+	z3 = MULTIPLY(z1+z2, FIX(1.105676686)) /* c6 */
+
+	tmp13 = z3 + MULTIPLY(z1, FIX(0.273079590)) /* c2-c6 */
+	tmp14 = z3 - MULTIPLY(z2, FIX(1.719280954)) /* c6+c10 */
+	tmp15 = MULTIPLY(z1, FIX(0.613604268)) -    /* c10 */
+		MULTIPLY(z2, FIX(1.378756276)) /* c2 */
+
+	tmp20 = tmp10 + tmp13
+	tmp26 = tmp10 - tmp13
+	tmp21 = tmp11 + tmp14
+	tmp25 = tmp11 - tmp14
+	tmp22 = tmp12 + tmp15
+	tmp24 = tmp12 - tmp15
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+	z4 <<= CONST_BITS
+
+	tmp14 = z1 + z3
+	tmp11 = MULTIPLY(z1+z2, FIX(1.334852607))                   /* c3 */
+	tmp12 = MULTIPLY(tmp14, FIX(1.197448846))                   /* c5 */
+	tmp10 = tmp11 + tmp12 + z4 - MULTIPLY(z1, FIX(1.126980169)) /* c3+c5-c1 */
+	tmp14 = MULTIPLY(tmp14, FIX(0.752406978))                   /* c9 */
+	tmp16 = tmp14 - MULTIPLY(z1, FIX(1.061150426))              /* c9+c11-c13 */
+	z1 -= z2
+	tmp15 = MULTIPLY(z1, FIX(0.467085129)) - z4 /* c11 */
+	tmp16 += tmp15
+	tmp13 = MULTIPLY(z2+z3, -FIX(0.158341681)) - z4       /* -c13 */
+	tmp11 += tmp13 - MULTIPLY(z2, FIX(0.424103948))       /* c3-c9-c13 */
+	tmp12 += tmp13 - MULTIPLY(z3, FIX(2.373959773))       /* c3+c5-c13 */
+	tmp13 = MULTIPLY(z3-z2, FIX(1.405321284))             /* c1 */
+	tmp14 += tmp13 + z4 - MULTIPLY(z3, FIX(1.6906431334)) /* c1+c9-c11 */
+	tmp15 += tmp13 + MULTIPLY(z2, FIX(0.674957567))       /* c1+c11-c5 */
+
+	tmp13 = ((z1 - z3) << CONST_BITS) + z4
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp10
+	out[13] = tmp20 - tmp10
+	out[1] = tmp21 + tmp11
+	out[12] = tmp21 - tmp11
+	out[2] = tmp22 + tmp12
+	out[11] = tmp22 - tmp12
+	out[3] = tmp23 + tmp13
+	out[10] = tmp23 - tmp13
+	out[4] = tmp24 + tmp14
+	out[9] = tmp24 - tmp14
+	out[5] = tmp25 + tmp15
+	out[8] = tmp25 - tmp15
+	out[6] = tmp26 + tmp16
+	out[7] = tmp26 - tmp16
+}
+
+/*
+ * 15-point IDCT with 22 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/30).
+ */
+func idct_1d_15(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp10, tmp11, tmp12, tmp13, tmp14, tmp15, tmp16 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24, tmp25, tmp26, tmp27 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	z1 = in[0]
+
+	z2 = in[2]
+	z3 = in[4]
+	z4 = in[6]
+
+	tmp10 = MULTIPLY(z4, FIX(0.437016024)) /* c12 */
+	tmp11 = MULTIPLY(z4, FIX(1.144122806)) /* c6 */
+
+	tmp12 = z1 - tmp10
+	tmp13 = z1 + tmp11
+	z1 -= (tmp11 - tmp10) << 1 /* c0 = (c6-c12)*2 */
+
+	z4 = z2 - z3
+	z3 += z2
+	tmp10 = MULTIPLY(z3, FIX(1.337628990)) /* (c2+c4)/2 */
+	tmp11 = MULTIPLY(z4, FIX(0.045680613)) /* (c2-c4)/2 */
+	z2 = MULTIPLY(z2, FIX(1.439773946))    /* c4+c14 */
+
+	tmp20 = tmp13 + tmp10 + tmp11
+	tmp23 = tmp12 - tmp10 + tmp11 + z2
+
+	tmp10 = MULTIPLY(z3, FIX(0.547059574)) /* (c8+c14)/2 */
+	tmp11 = MULTIPLY(z4, FIX(0.399234004)) /* (c8-c14)/2 */
+
+	tmp25 = tmp13 - tmp10 - tmp11
+	tmp26 = tmp12 + tmp10 - tmp11 - z2
+
+	tmp10 = MULTIPLY(z3, FIX(0.790569415)) /* (c6+c12)/2 */
+	tmp11 = MULTIPLY(z4, FIX(0.353553391)) /* (c6-c12)/2 */
+
+	tmp21 = tmp12 + tmp10 + tmp11
+	tmp24 = tmp13 - tmp10 + tmp11
+	tmp11 += tmp11
+	tmp22 = z1 + tmp11         /* c10 = c6-c12 */
+	tmp27 = z1 - tmp11 - tmp11 /* c0 = (c6-c12)*2 */
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z4 = in[5]
+	z3 = MULTIPLY(z4, FIX(1.224744871)) /* c5 */
+	z4 = in[7]
+
+	tmp13 = z2 - z4
+	tmp15 = MULTIPLY(z1+tmp13, FIX(0.831253876))      /* c9 */
+	tmp11 = tmp15 + MULTIPLY(z1, FIX(0.513743148))    /* c3-c9 */
+	tmp14 = tmp15 - MULTIPLY(tmp13, FIX(2.176250899)) /* c3+c9 */
+
+	tmp13 = MULTIPLY(z2, -FIX(0.831253876)) /* -c9 */
+	tmp15 = MULTIPLY(z2, -FIX(1.344997024)) /* -c3 */
+	z2 = z1 - z4
+	tmp12 = z3 + MULTIPLY(z2, FIX(1.406466353)) /* c1 */
+
+	tmp10 = tmp12 + MULTIPLY(z4, FIX(2.457431844)) - tmp15 /* c1+c7 */
+	tmp16 = tmp12 - MULTIPLY(z1, FIX(1.112434820)) + tmp13 /* c1-c13 */
+	tmp12 = MULTIPLY(z2, FIX(1.224744871)) - z3            /* c5 */
+	z2 = MULTIPLY(z1+z4, FIX(0.575212477))                 /* c11 */
+	tmp13 += z2 + MULTIPLY(z1, FIX(0.475753014)) - z3      /* c7-c11 */
+	tmp15 += z2 - MULTIPLY(z4, FIX(0.869244010)) + z3      /* c11+c13 */
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp10
+	out[14] = tmp20 - tmp10
+	out[1] = tmp21 + tmp11
+	out[13] = tmp21 - tmp11
+	out[2] = tmp22 + tmp12
+	out[12] = tmp22 - tmp12
+	out[3] = tmp23 + tmp13
+	out[11] = tmp23 - tmp13
+	out[4] = tmp24 + tmp14
+	out[10] = tmp24 - tmp14
+	out[5] = tmp25 + tmp15
+	out[9] = tmp25 - tmp15
+	out[6] = tmp26 + tmp16
+	out[8] = tmp26 - tmp16
+	out[7] = tmp27
+}
+
+/*
+ * 16-point IDCT with 28 multiplications.
+ * cK represents sqrt(2) * cos(K*pi/32).
+ */
+func idct_1d_16(in *[DCTSIZE]int32, out *[maxDCTSize]int32) {
+	var tmp0, tmp1, tmp2, tmp3, tmp10, tmp11, tmp12, tmp13 int32
+	var tmp20, tmp21, tmp22, tmp23, tmp24, tmp25, tmp26, tmp27 int32
+	var z1, z2, z3, z4 int32
+
+	/* Even part */
+
+	tmp0 = in[0]
+
+	z1 = in[4]
+	tmp1 = MULTIPLY(z1, FIX(1.306562965)) /* c4[16] = c2[8] */
+	tmp2 = MULTIPLY(z1, FIX_0_541196100)  /* c12[16] = c6[8] */
+
+	tmp10 = tmp0 + tmp1
+	tmp11 = tmp0 - tmp1
+	tmp12 = tmp0 + tmp2
+	tmp13 = tmp0 - tmp2
+
+	z1 = in[2]
+	z2 = in[6]
+	z3 = z1 - z2
+	z4 = MULTIPLY(z3, FIX(0.275899379)) /* c14[16] = c7[8] */
+	z3 = MULTIPLY(z3, FIX(1.387039845)) /* c2[16] = c1[8] */
+
+	tmp0 = z3 + MULTIPLY(z2, FIX_2_562915447)  /* (c6+c2)[16] = (c3+c1)[8] */
+	tmp1 = z4 + MULTIPLY(z1, FIX_0_899976223)  /* (c6-c14)[16] = (c3-c7)[8] */
+	tmp2 = z3 - MULTIPLY(z1, FIX(0.601344887)) /* (c2-c10)[16] = (c1-c5)[8] */
+	tmp3 = z4 - MULTIPLY(z2, FIX(0.509795579)) /* (c10-c14)[16] = (c5-c7)[8] */
+
+	tmp20 = tmp10 + tmp0
+	tmp27 = tmp10 - tmp0
+	tmp21 = tmp12 + tmp1
+	tmp26 = tmp12 - tmp1
+	tmp22 = tmp13 + tmp2
+	tmp25 = tmp13 - tmp2
+	tmp23 = tmp11 + tmp3
+	tmp24 = tmp11 - tmp3
+
+	/* Odd part */
+
+	z1 = in[1]
+	z2 = in[3]
+	z3 = in[5]
+	z4 = in[7]
+
+	tmp11 = z1 + z3
+
+	tmp1 = MULTIPLY(z1+z2, FIX(1.353318001))  /* c3 */
+	tmp2 = MULTIPLY(tmp11, FIX(1.247225013))  /* c5 */
+	tmp3 = MULTIPLY(z1+z4, FIX(1.093201867))  /* c7 */
+	tmp10 = MULTIPLY(z1-z4, FIX(0.897167586)) /* c9 */
+	tmp11 = MULTIPLY(tmp11, FIX(0.666655658)) /* c11 */
+	tmp12 = MULTIPLY(z1-z2, FIX(0.410524528)) /* c13 */
+	tmp0 = tmp1 + tmp2 + tmp3 -
+		MULTIPLY(z1, FIX(2.286341144)) /* c7+c5+c3-c1 */
+	tmp13 = tmp10 + tmp11 + tmp12 -
+		MULTIPLY(z1, FIX(1.835730603)) /* c9+c11+c13-c15 */
+	z1 = MULTIPLY(z2+z3, FIX(0.138617169))       /* c15 */
+	tmp1 += z1 + MULTIPLY(z2, FIX(0.071888074))  /* c9+c11-c3-c15 */
+	tmp2 += z1 - MULTIPLY(z3, FIX(1.125726048))  /* c5+c7+c15-c3 */
+	z1 = MULTIPLY(z3-z2, FIX(1.407403738))       /* c1 */
+	tmp11 += z1 - MULTIPLY(z3, FIX(0.766367282)) /* c1+c11-c9-c13 */
+	tmp12 += z1 + MULTIPLY(z2, FIX(1.971951411)) /* c1+c5+c13-c7 */
+	z2 += z4
+	z1 = MULTIPLY(z2, -FIX(0.666655658)) /* -c11 */
+	tmp1 += z1
+	tmp3 += z1 + MULTIPLY(z4, FIX(1.065388962))  /* c3+c11+c15-c7 */
+	z2 = MULTIPLY(z2, -FIX(1.247225013))         /* -c5 */
+	tmp10 += z2 + MULTIPLY(z4, FIX(3.141271809)) /* c1+c5+c9-c13 */
+	tmp12 += z2
+	z2 = MULTIPLY(z3+z4, -FIX(1.353318001)) /* -c3 */
+	tmp2 += z2
+	tmp3 += z2
+	z2 = MULTIPLY(z4-z3, FIX(0.410524528)) /* c13 */
+	tmp10 += z2
+	tmp11 += z2
+
+	/* Final output stage */
+
+	out[0] = tmp20 + tmp0
+	out[15] = tmp20 - tmp0
+	out[1] = tmp21 + tmp1
+	out[14] = tmp21 - tmp1
+	out[2] = tmp22 + tmp2
+	out[13] = tmp22 - tmp2
+	out[3] = tmp23 + tmp3
+	out[12] = tmp23 - tmp3
+	out[4] = tmp24 + tmp10
+	out[11] = tmp24 - tmp10
+	out[5] = tmp25 + tmp11
+	out[10] = tmp25 - tmp11
+	out[6] = tmp26 + tmp12
+	out[9] = tmp26 - tmp12
+	out[7] = tmp27 + tmp13
+	out[8] = tmp27 - tmp13
+}
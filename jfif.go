@@ -0,0 +1,94 @@
+package jpegscaled
+
+import (
+	"image"
+	"image/color"
+)
+
+// JFXX extension codes, as specified in the JFIF specification, version
+// 1.02.
+const (
+	jfxxJPEG    = 0x10 // Thumbnail coded using JPEG.
+	jfxxPalette = 0x11 // Thumbnail stored using 1 byte per pixel.
+	jfxxRGB     = 0x13 // Thumbnail stored using 3 bytes per pixel.
+)
+
+// processJFXX reads the rest of a JFXX extension APP0 segment, after its
+// identifier, and keeps its thumbnail unless there already is one.
+func (d *decoder) processJFXX(n int) error {
+	if n < 1 || d.jfifThumb != nil || d.jfxxJPEG != nil {
+		return d.ignore(n)
+	}
+	if err := d.readFull(d.tmp[:1]); err != nil {
+		return err
+	}
+	n--
+	code := d.tmp[0]
+	if code == jfxxJPEG {
+		data := make([]byte, n)
+		if err := d.readFull(data); err != nil {
+			return err
+		}
+		d.jfxxJPEG = data
+		return nil
+	}
+	if n < 2 || code != jfxxPalette && code != jfxxRGB {
+		return d.ignore(n)
+	}
+	if err := d.readFull(d.tmp[:2]); err != nil {
+		return err
+	}
+	n -= 2
+	w, h := int(d.tmp[0]), int(d.tmp[1])
+	size := 3 * w * h
+	if code == jfxxPalette {
+		size = 3*256 + w*h
+	}
+	if w*h == 0 || size > n {
+		return d.ignore(n)
+	}
+	var (
+		m   image.Image
+		err error
+	)
+	if code == jfxxRGB {
+		m, err = d.readRGBThumbnail(w, h)
+	} else {
+		m, err = d.readPaletteThumbnail(w, h)
+	}
+	if err != nil {
+		return err
+	}
+	d.jfifThumb = m
+	return d.ignore(n - size)
+}
+
+// readRGBThumbnail reads a w x h thumbnail of 3 bytes per pixel.
+func (d *decoder) readRGBThumbnail(w, h int) (*image.RGBA, error) {
+	rgb := make([]byte, 3*w*h)
+	if err := d.readFull(rgb); err != nil {
+		return nil, err
+	}
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		copy(m.Pix[4*i:4*i+3], rgb[3*i:3*i+3])
+		m.Pix[4*i+3] = 0xff
+	}
+	return m, nil
+}
+
+// readPaletteThumbnail reads a w x h thumbnail of 1 byte per pixel, after its
+// 256-entry RGB palette.
+func (d *decoder) readPaletteThumbnail(w, h int) (*image.Paletted, error) {
+	data := make([]byte, 3*256+w*h)
+	if err := d.readFull(data); err != nil {
+		return nil, err
+	}
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{data[3*i], data[3*i+1], data[3*i+2], 0xff}
+	}
+	m := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+	copy(m.Pix, data[3*256:])
+	return m, nil
+}
@@ -0,0 +1,139 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// app0Segment returns an APP0 segment with the given payload.
+func app0Segment(payload []byte) []byte {
+	seg := []byte{0xff, app0Marker}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testThumbnail returns a w x h RGBA image with a distinct colour per pixel,
+// and its pixels as packed RGB.
+func testThumbnail(w, h int) (*image.RGBA, []byte) {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	var rgb []byte
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{uint8(40 * x), uint8(40 * y), uint8(x * y), 0xff}
+			m.SetRGBA(x, y, c)
+			rgb = append(rgb, c.R, c.G, c.B)
+		}
+	}
+	return m, rgb
+}
+
+// TestJFIFThumbnail tests that JFIF and JFXX thumbnails of every form are
+// reported by DecodeConfig, JPEG ones undecoded, and that the JFIF fields of
+// the image are kept.
+func TestJFIFThumbnail(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := os.ReadFile("testdata/video-005.gray.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgbThumb, rgb := testThumbnail(5, 3)
+
+	var palette, indices []byte
+	for i := 0; i < 256; i++ {
+		palette = append(palette, uint8(i), uint8(255-i), uint8(i/2))
+	}
+	paletteThumb := image.NewPaletted(image.Rect(0, 0, 4, 2), nil)
+	for i := 0; i < 256; i++ {
+		paletteThumb.Palette = append(paletteThumb.Palette, color.RGBA{uint8(i), uint8(255 - i), uint8(i / 2), 0xff})
+	}
+	for i := range paletteThumb.Pix {
+		paletteThumb.Pix[i] = uint8(37 * i)
+		indices = append(indices, uint8(37*i))
+	}
+
+	jfif := []byte("JFIF\x00\x01\x02\x01\x01\x2c\x01\x2c\x05\x03")
+	for _, tc := range []struct {
+		desc     string
+		payload  []byte
+		want     image.Image
+		wantJPEG []byte
+		version  uint16
+	}{
+		{"none", nil, nil, nil, 0x0101},
+		{"JFIF RGB", append(jfif, rgb...), rgbThumb, nil, 0x0102},
+		{"JFXX JPEG", append([]byte("JFXX\x00\x10"), thumb...), nil, thumb, 0x0101},
+		// A JPEG thumbnail is not decoded, so its errors do not show.
+		{"malformed JFXX JPEG", []byte("JFXX\x00\x10\xff\xd8\xff\xc0junk"), nil, []byte("\xff\xd8\xff\xc0junk"), 0x0101},
+		{"JFXX palette", append(append([]byte("JFXX\x00\x11\x04\x02"), palette...), indices...), paletteThumb, nil, 0x0101},
+		{"JFXX RGB", append([]byte("JFXX\x00\x13\x05\x03"), rgb...), rgbThumb, nil, 0x0101},
+		{"truncated JFXX RGB", append([]byte("JFXX\x00\x13\x05\x04"), rgb...), nil, nil, 0x0101},
+	} {
+		input := data
+		if tc.payload != nil {
+			// A JFIF segment replaces the image's own, which is its first
+			// 18-byte segment, and a JFXX segment follows it.
+			seg := app0Segment(tc.payload)
+			if tc.payload[3] == 'F' {
+				input = append(append(append([]byte{}, data[:2]...), seg...), data[20:]...)
+			} else {
+				input = append(append(append([]byte{}, data[:20]...), seg...), data[20:]...)
+			}
+		}
+		cfg, err := DecodeConfig(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		if !cfg.JFIF || cfg.JFIFVersion != tc.version {
+			t.Errorf("%s: got JFIF %t version %#04x, want true %#04x", tc.desc, cfg.JFIF, cfg.JFIFVersion, tc.version)
+		}
+		if !bytes.Equal(cfg.JFIFThumbnailJPEG, tc.wantJPEG) {
+			t.Errorf("%s: got %d bytes of JPEG thumbnail, want %d", tc.desc, len(cfg.JFIFThumbnailJPEG), len(tc.wantJPEG))
+		}
+		if tc.want == nil {
+			if cfg.JFIFThumbnail != nil {
+				t.Errorf("%s: got thumbnail %T, want nil", tc.desc, cfg.JFIFThumbnail)
+			}
+			continue
+		}
+		if cfg.JFIFThumbnail == nil {
+			t.Errorf("%s: got no thumbnail", tc.desc)
+			continue
+		}
+		if err := equalImages(cfg.JFIFThumbnail, tc.want); err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+		}
+	}
+}
+
+// TestDecodeEmbeddedJFXXThumbnail tests that DecodeEmbeddedThumbnail falls
+// back to a JFXX JPEG thumbnail, decoded with the given options.
+func TestDecodeEmbeddedJFXXThumbnail(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := os.ReadFile("testdata/video-005.gray.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DecodeOptions{DCTSizeScaled: 4}
+	want, err := Decode(bytes.NewReader(thumb), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := insertSegment(data, app0Segment(append([]byte("JFXX\x00\x10"), thumb...)))
+	m, err := DecodeEmbeddedThumbnail(bytes.NewReader(input), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Error(err)
+	}
+}
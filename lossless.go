@@ -0,0 +1,210 @@
+package jpegscaled
+
+// samplePlane holds the samples of a component of a lossless image, as
+// specified in Annex H. The plane covers whole 8x8 sample blocks, so that it
+// can be scaled by the same block layout as DCT images, but only the first
+// w x h samples are the component's.
+type samplePlane struct {
+	pix    []uint16
+	stride int
+	w, h   int
+}
+
+// at returns the sample at (x, y), clamped to the component's samples.
+func (p *samplePlane) at(x, y int) int32 {
+	return int32(p.pix[min(y, p.h-1)*p.stride+min(x, p.w-1)])
+}
+
+// makeSamplePlanes allocates the sample planes of a lossless image.
+func (d *decoder) makeSamplePlanes() {
+	mxx := (d.width + 8*d.hMax - 1) / (8 * d.hMax)
+	myy := (d.height + 8*d.vMax - 1) / (8 * d.vMax)
+	for i := 0; i < d.nComp; i++ {
+		c := &d.comp[i]
+		stride := 8 * c.h * mxx
+		d.samples[i] = &samplePlane{
+			pix:    make([]uint16, stride*8*c.v*myy),
+			stride: stride,
+			w:      (d.width*c.h + d.hMax - 1) / d.hMax,
+			h:      (d.height*c.v + d.vMax - 1) / d.vMax,
+		}
+	}
+}
+
+// predict returns the prediction of the sample at (x, y) for the predictor
+// selection value psv, as specified in section H.1.2.1. firstRow is the row
+// at which the scan or the current restart interval started, whose first
+// sample is predicted as initial.
+func (p *samplePlane) predict(x, y, firstRow, psv int, initial int32) int32 {
+	i := y*p.stride + x
+	switch {
+	case y == firstRow && x == 0:
+		return initial
+	case y == firstRow:
+		return int32(p.pix[i-1])
+	case x == 0:
+		return int32(p.pix[i-p.stride])
+	}
+	ra, rb, rc := int32(p.pix[i-1]), int32(p.pix[i-p.stride]), int32(p.pix[i-p.stride-1])
+	switch psv {
+	case 1:
+		return ra
+	case 2:
+		return rb
+	case 3:
+		return rc
+	case 4:
+		return ra + rb - rc
+	case 5:
+		return ra + (rb-rc)>>1
+	case 6:
+		return rb + (ra-rc)>>1
+	}
+	return (ra + rb) >> 1
+}
+
+// decodeLossless decodes a lossless scan into the sample planes. The
+// predictor selection value is in sh.zigStart and the point transform in
+// sh.al, as they share the Ss and Al fields of the scan header.
+func (d *decoder) decodeLossless(sh *scanHeader) error {
+	psv, pt := int(sh.zigStart), int(sh.al)
+	initial := int32(1) << (d.precision - pt - 1)
+
+	// mxx and myy are the number of MCUs in the scan. An MCU of an
+	// interleaved scan holds h x v samples of every component, and that of a
+	// non-interleaved scan a single sample, as per section H.2.
+	var mxx, myy int
+	if sh.nComp == 1 {
+		p := d.samples[sh.comp[0].compIndex]
+		mxx, myy = p.w, p.h
+	} else {
+		mxx, myy = (d.width+d.hMax-1)/d.hMax, (d.height+d.vMax-1)/d.vMax
+	}
+	if d.ri > 0 && d.ri%mxx != 0 {
+		return UnsupportedError("lossless restart interval that is not a whole number of rows")
+	}
+
+	d.bits = bits{}
+	expectedRST := uint8(rst0Marker)
+	// firstRow is the first MCU row of the scan or restart interval.
+	firstRow := 0
+	for mcu := 0; mcu < mxx*myy; {
+		mx, my := mcu%mxx, mcu/mxx
+		for i := 0; i < sh.nComp; i++ {
+			compIndex := sh.comp[i].compIndex
+			p := d.samples[compIndex]
+			hi, vi := d.comp[compIndex].h, d.comp[compIndex].v
+			if sh.nComp == 1 {
+				hi, vi = 1, 1
+			}
+			for j := 0; j < hi*vi; j++ {
+				x, y := hi*mx+j%hi, vi*my+j/hi
+				// Decode the difference, as specified in section H.1.2.2.
+				s, err := d.decodeHuffman(&d.huff[dcTable][sh.comp[i].td])
+				if err != nil {
+					return err
+				}
+				var diff int32
+				switch {
+				case s == 16:
+					diff = 32768
+				case s > 16:
+					return FormatError("excessive lossless difference")
+				case s > 0:
+					if diff, err = d.receiveExtend(s); err != nil {
+						return err
+					}
+				}
+				pred := p.predict(x, y, vi*firstRow, psv, initial)
+				p.pix[y*p.stride+x] = uint16(pred + diff)
+			}
+		}
+		mcu++
+		if d.ri > 0 && mcu%d.ri == 0 && mcu < mxx*myy {
+			if err := d.readFull(d.tmp[:2]); err != nil {
+				return err
+			} else if d.tmp[0] != 0xff || d.tmp[1] != expectedRST {
+				if err := d.findRST(expectedRST); err != nil {
+					return err
+				}
+			}
+			expectedRST++
+			if expectedRST == rst7Marker+1 {
+				expectedRST = rst0Marker
+			}
+			d.bits = bits{}
+			firstRow = mcu / mxx
+		}
+	}
+	for i := 0; i < sh.nComp; i++ {
+		d.pointTransform[sh.comp[i].compIndex] = pt
+	}
+	return nil
+}
+
+// reconstructLosslessImage scales the sample planes of a lossless image to
+// the image, averaging the samples that every output sample covers, so that
+// DCTSizeScaled has the same meaning as for DCT images. Enlarged images
+// repeat the samples.
+func (d *decoder) reconstructLosslessImage() error {
+	// The rows of blocks are the same as those of
+	// reconstructProgressiveImage, for the same layout of the image.
+	type blockRow struct{ compIndex, by int }
+	var rows []blockRow
+	for i := 0; i < d.nComp; i++ {
+		vi := d.comp[i].v
+		for by := d.cropMCU.Min.Y * vi; by < d.cropMCU.Max.Y*vi && d.blockInFrame(0, by, i); by++ {
+			rows = append(rows, blockRow{i, by})
+		}
+	}
+	maxSample := int32(1)<<d.precision - 1
+	return d.forEach(len(rows), func() func(k int) error {
+		var pix [maxDCTSize * maxDCTSize]int32
+		return func(k int) error {
+			i, by := rows[k].compIndex, rows[k].by
+			p, pt := d.samples[i], d.pointTransform[i]
+			hi := d.comp[i].h
+			bw, bh := d.blockSize(i)
+			for bx := d.cropMCU.Min.X * hi; bx < d.cropMCU.Max.X*hi && d.blockInFrame(bx, by, i); bx++ {
+				for y := 0; y < bh; y++ {
+					y0 := y * DCTSIZE / bh
+					y1 := max((y+1)*DCTSIZE/bh, y0+1)
+					for x := 0; x < bw; x++ {
+						x0 := x * DCTSIZE / bw
+						x1 := max((x+1)*DCTSIZE/bw, x0+1)
+						sum := int64(0)
+						for sy := y0; sy < y1; sy++ {
+							for sx := x0; sx < x1; sx++ {
+								sum += int64(p.at(8*bx+sx, 8*by+sy))
+							}
+						}
+						n := int64((y1 - y0) * (x1 - x0))
+						c := min(int32((sum+n/2)/n)<<pt, maxSample)
+						if d.precision < 8 {
+							c = scaleSample(c, d.precision, 8)
+						}
+						pix[y*bw+x] = c
+					}
+				}
+				if err := d.storeBlock(pix[:bw*bh], bw, bh, bx, by, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+}
+
+// scaleSample scales a sample of precision bits to n bits, with n greater
+// than precision, by replicating its bits.
+func scaleSample(c int32, precision, n int) int32 {
+	r := int32(0)
+	for s := n - precision; s > -precision; s -= precision {
+		if s >= 0 {
+			r |= c << s
+		} else {
+			r |= c >> -s
+		}
+	}
+	return r
+}
@@ -0,0 +1,255 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// losslessParams are the parameters of a lossless image made by
+// encodeLossless.
+type losslessParams struct {
+	precision, psv, pt int
+	// ri is the restart interval, a multiple of the width.
+	ri int
+	// separate is whether every component has its own scan.
+	separate bool
+}
+
+// encodeLossless encodes w x h planes, whose components have the given
+// identifiers and sampling factors of 1, as a lossless (SOF3) JPEG image.
+// The Huffman table codes every difference category with 5 bits.
+func encodeLossless(planes [][]uint16, w, h int, ids []byte, lp losslessParams) []byte {
+	segment := func(data []byte, marker byte, payload []byte) []byte {
+		data = append(data, 0xff, marker)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(payload)+2))
+		return append(data, payload...)
+	}
+	data := []byte{0xff, soiMarker}
+	sof := []byte{byte(lp.precision), byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(len(planes))}
+	for _, id := range ids {
+		sof = append(sof, id, 0x11, 0)
+	}
+	data = segment(data, sof3Marker, sof)
+	codes := map[byte]uint32{}
+	dht := []byte{0x00, 0, 0, 0, 0, 17, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for s := byte(0); s <= 16; s++ {
+		codes[s] = uint32(s)
+		dht = append(dht, s)
+	}
+	data = segment(data, dhtMarker, dht)
+	if lp.ri > 0 {
+		data = segment(data, driMarker, []byte{byte(lp.ri >> 8), byte(lp.ri)})
+	}
+
+	// predict implements Table H.1 and section H.1.2.1 on the point
+	// transformed samples of a plane.
+	predict := func(p []uint16, x, y, firstRow int) int32 {
+		switch {
+		case y == firstRow && x == 0:
+			return 1 << (lp.precision - lp.pt - 1)
+		case y == firstRow:
+			return int32(p[y*w+x-1] >> lp.pt)
+		case x == 0:
+			return int32(p[(y-1)*w+x] >> lp.pt)
+		}
+		a, b, c := int32(p[y*w+x-1]>>lp.pt), int32(p[(y-1)*w+x]>>lp.pt), int32(p[(y-1)*w+x-1]>>lp.pt)
+		return [8]int32{0, a, b, c, a + b - c, a + (b-c)>>1, b + (a-c)>>1, (a + b) / 2}[lp.psv]
+	}
+	scan := func(comps []int) {
+		sos := []byte{byte(len(comps))}
+		for _, c := range comps {
+			sos = append(sos, ids[c], 0x00)
+		}
+		data = segment(data, sosMarker, append(sos, byte(lp.psv), 0, byte(lp.pt)))
+		var bits bitWriter
+		firstRow, rst := 0, 0
+		for mcu := 0; mcu < w*h; mcu++ {
+			x, y := mcu%w, mcu/w
+			if lp.ri > 0 && mcu > 0 && mcu%lp.ri == 0 {
+				bits.flush()
+				data = append(data, bits.buf...)
+				data = append(data, 0xff, byte(rst0Marker+rst%8))
+				bits, firstRow = bitWriter{}, y
+				rst++
+			}
+			for _, c := range comps {
+				diff := (int32(planes[c][y*w+x]>>lp.pt) - predict(planes[c], x, y, firstRow)) & 0xffff
+				if diff > 0x8000 {
+					diff -= 0x10000
+				}
+				if s := category(diff); s == 16 {
+					bits.write(codes[16], 5)
+				} else {
+					bits.writeValue(byte(s), s, diff, codes, 5)
+				}
+			}
+		}
+		bits.flush()
+		data = append(data, bits.buf...)
+	}
+	if lp.separate {
+		for c := range planes {
+			scan([]int{c})
+		}
+	} else {
+		all := make([]int, len(planes))
+		for i := range all {
+			all[i] = i
+		}
+		scan(all)
+	}
+	return append(data, 0xff, eoiMarker)
+}
+
+// randomPlane returns a w x h plane of random samples of the given precision
+// that mixes smooth areas with noise.
+func randomPlane(rng *rand.Rand, w, h, precision int) []uint16 {
+	p := make([]uint16, w*h)
+	for i := range p {
+		if rng.Intn(4) == 0 {
+			p[i] = uint16(rng.Intn(1 << precision))
+		} else {
+			p[i] = uint16((i % w) * (1<<precision - 1) / w)
+		}
+	}
+	return p
+}
+
+// TestDecodeLossless tests that lossless images decode to their samples, for
+// every predictor and a range of precisions, point transforms and layouts.
+func TestDecodeLossless(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const w, h = 13, 11
+	for _, nComp := range []int{1, 3} {
+		for _, precision := range []int{2, 8, 12, 16} {
+			if nComp == 3 && precision == 2 {
+				continue
+			}
+			for psv := 1; psv <= 7; psv++ {
+				lp := losslessParams{precision: precision, psv: psv}
+				switch psv {
+				case 2:
+					lp.pt = 1
+				case 3:
+					lp.ri = 2 * w
+				case 4:
+					lp.separate = true
+				}
+				planes := make([][]uint16, nComp)
+				for i := range planes {
+					planes[i] = randomPlane(rng, w, h, precision)
+				}
+				ids := []byte{1}
+				if nComp == 3 {
+					ids = []byte{'R', 'G', 'B'}
+				}
+				data := encodeLossless(planes, w, h, ids, lp)
+
+				cfg, err := DecodeConfig(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cfg.JpegType != JpegTypeLossless || cfg.Precision != precision {
+					t.Errorf("%+v: got type %v, precision %d", lp, cfg.JpegType, cfg.Precision)
+				}
+				m, err := Decode(bytes.NewReader(data), DecodeOptions{})
+				if err != nil {
+					t.Fatalf("%d components, %+v: %v", nComp, lp, err)
+				}
+				if m.Bounds() != image.Rect(0, 0, w, h) {
+					t.Fatalf("%d components, %+v: got bounds %v", nComp, lp, m.Bounds())
+				}
+				for y := 0; y < h; y++ {
+					for x := 0; x < w; x++ {
+						for c := range planes {
+							v := int32(planes[c][y*w+x] >> lp.pt << lp.pt)
+							var got, want int32
+							switch m := m.(type) {
+							case *image.Gray:
+								got, want = int32(m.GrayAt(x, y).Y), scaleSample(v, precision, 8)
+							case *image.Gray16:
+								got, want = int32(m.Gray16At(x, y).Y), scaleSample(v, precision, 16)
+							case *image.RGBA:
+								got, want = int32(m.Pix[m.PixOffset(x, y)+c]), v
+							case *image.RGBA64:
+								i := m.PixOffset(x, y) + 2*c
+								got, want = int32(m.Pix[i])<<8|int32(m.Pix[i+1]), scaleSample(v, precision, 16)
+							default:
+								t.Fatalf("%d components, %+v: got %T", nComp, lp, m)
+							}
+							if precision == 8 {
+								want = v
+							}
+							if got != want {
+								t.Fatalf("%d components, %+v: (%d, %d) channel %d: got %d, want %d", nComp, lp, x, y, c, got, want)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeLosslessScaled tests that scaled lossless images average or
+// repeat the samples.
+func TestDecodeLosslessScaled(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	const w, h = 20, 12
+	src := randomPlane(rng, w, h, 8)
+	data := encodeLossless([][]uint16{src}, w, h, []byte{1}, losslessParams{precision: 8, psv: 1})
+	for _, tc := range []struct {
+		size    int
+		want    func(x, y int) uint8
+		reduced image.Rectangle
+	}{
+		{4, func(x, y int) uint8 {
+			sum := 0
+			for _, p := range [4]int{0, 1, w, w + 1} {
+				sum += int(src[2*y*w+2*x+p])
+			}
+			return uint8((sum + 2) / 4)
+		}, image.Rect(0, 0, w/2, h/2)},
+		{16, func(x, y int) uint8 { return uint8(src[y/2*w+x/2]) }, image.Rect(0, 0, 2*w, 2*h)},
+	} {
+		m, err := Decode(bytes.NewReader(data), DecodeOptions{DCTSizeScaled: tc.size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, ok := m.(*image.Gray)
+		if !ok || g.Bounds() != tc.reduced {
+			t.Fatalf("size %d: got %T with bounds %v", tc.size, m, m.Bounds())
+		}
+		for y := 0; y < tc.reduced.Dy(); y++ {
+			for x := 0; x < tc.reduced.Dx(); x++ {
+				if got, want := g.GrayAt(x, y).Y, tc.want(x, y); got != want {
+					t.Fatalf("size %d: (%d, %d): got %d, want %d", tc.size, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestScaleSample tests that scaled samples span the full range.
+func TestScaleSample(t *testing.T) {
+	for _, tc := range []struct {
+		c            int32
+		precision, n int
+		want         int32
+	}{
+		{0, 2, 8, 0},
+		{3, 2, 8, 255},
+		{2, 2, 8, 0xaa},
+		{5, 3, 8, 0xb6},
+		{4095, 12, 16, 65535},
+		{0x800, 12, 16, 0x8008},
+		{0x1ff, 9, 16, 0xffff},
+	} {
+		if got := scaleSample(tc.c, tc.precision, tc.n); got != tc.want {
+			t.Errorf("scaleSample(%#x, %d, %d) = %#x, want %#x", tc.c, tc.precision, tc.n, got, tc.want)
+		}
+	}
+}
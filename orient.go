@@ -0,0 +1,134 @@
+package jpegscaled
+
+import (
+	"image"
+)
+
+// transposes returns whether the EXIF orientation o swaps the image's width
+// and height.
+func transposes(o int) bool {
+	return 5 <= o && o <= 8
+}
+
+// orientSource returns the point of a w x h image, stored with the EXIF
+// orientation o, that is shown at x, y once the image is oriented for
+// display. Both points are relative to the images' top-left corners.
+func orientSource(o, x, y, w, h int) (int, int) {
+	switch o {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, h - 1 - x
+	case 7:
+		return w - 1 - y, h - 1 - x
+	case 8:
+		return w - 1 - y, x
+	}
+	return x, y
+}
+
+// orient returns m rotated and flipped for display according to the EXIF
+// orientation o, with bounds starting at 0, 0. It supports the image types
+// produced by the decoder and returns m unchanged for any other type or if o
+// needs no transform.
+func orient(m image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return m
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	r := image.Rect(0, 0, w, h)
+	if transposes(o) {
+		r = image.Rect(0, 0, h, w)
+	}
+	switch m := m.(type) {
+	case *image.Gray:
+		dst := image.NewGray(r)
+		orientPlane(dst.Pix, dst.Stride, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, w, h, 1, o)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(r)
+		orientPlane(dst.Pix, dst.Stride, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, w, h, 2, o)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(r)
+		orientPlane(dst.Pix, dst.Stride, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, w, h, 4, o)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(r)
+		orientPlane(dst.Pix, dst.Stride, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, w, h, 8, o)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(r)
+		orientPlane(dst.Pix, dst.Stride, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, w, h, 4, o)
+		return dst
+	case *image.YCbCr:
+		// The chroma samples of m line up with those of the oriented image if
+		// the edges of m that become its top and left edges are on m's chroma
+		// grid. Otherwise, and if there is no ratio for the transposed
+		// subsampling, chroma is stored at full resolution.
+		ratio := m.SubsampleRatio
+		hDiv, vDiv := subsampleDivisors(ratio)
+		sx, sy := orientSource(o, 0, 0, w, h)
+		edgeX, edgeY := b.Min.X, b.Min.Y
+		if sx != 0 {
+			edgeX = b.Max.X
+		}
+		if sy != 0 {
+			edgeY = b.Max.Y
+		}
+		if w > 1 && edgeX%hDiv != 0 || h > 1 && edgeY%vDiv != 0 {
+			ratio = image.YCbCrSubsampleRatio444
+		}
+		if transposes(o) {
+			switch ratio {
+			case image.YCbCrSubsampleRatio422:
+				ratio = image.YCbCrSubsampleRatio440
+			case image.YCbCrSubsampleRatio440:
+				ratio = image.YCbCrSubsampleRatio422
+			case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+				ratio = image.YCbCrSubsampleRatio444
+			}
+		}
+		dst := image.NewYCbCr(r, ratio)
+		orientPlane(dst.Y, dst.YStride, m.Y[m.YOffset(b.Min.X, b.Min.Y):], m.YStride, w, h, 1, o)
+		// Every chroma sample is taken from the source chroma sample of the
+		// top-left luma sample that it covers.
+		dc := chromaRect(r, ratio)
+		hDiv, vDiv = subsampleDivisors(ratio)
+		for cy := 0; cy < dc.Dy(); cy++ {
+			for cx := 0; cx < dc.Dx(); cx++ {
+				sx, sy := orientSource(o, cx*hDiv, cy*vDiv, w, h)
+				si := m.COffset(b.Min.X+sx, b.Min.Y+sy)
+				di := cy*dst.CStride + cx
+				dst.Cb[di] = m.Cb[si]
+				dst.Cr[di] = m.Cr[si]
+			}
+		}
+		return dst
+	}
+	return m
+}
+
+// orientPlane writes the w x h plane src, of n-byte samples, to dst
+// transformed for the EXIF orientation o.
+func orientPlane(dst []byte, dstStride int, src []byte, srcStride, w, h, n, o int) {
+	dw, dh := w, h
+	if transposes(o) {
+		dw, dh = h, w
+	}
+	for y := 0; y < dh; y++ {
+		d := dst[y*dstStride : y*dstStride+dw*n]
+		for x := 0; x < dw; x++ {
+			sx, sy := orientSource(o, x, y, w, h)
+			s := sy*srcStride + sx*n
+			copy(d[x*n:x*n+n], src[s:s+n])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"os"
+	"testing"
+)
+
+// TestDecodeAutoOrient tests that AutoOrient gives the image decoded without
+// it, rotated and flipped.
+func TestDecodeAutoOrient(t *testing.T) {
+	for _, filename := range []string{
+		"testdata/video-005.gray.jpeg",
+		"testdata/video-001.jpeg",
+		"testdata/video-001.q50.422.jpeg",
+		"testdata/video-001.q50.410.progressive.jpeg",
+		"testdata/video-001.rgb.jpeg",
+		"testdata/video-001.cmyk.jpeg",
+	} {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range []DecodeOptions{
+			{DCTSizeScaled: 4},
+			{},
+			{Crop: image.Rect(31, 17, 121, 88)},
+		} {
+			for o := 1; o <= 8; o++ {
+				input := insertSegment(data, exifSegment(binary.BigEndian, uint16(o), nil))
+				plain, err := Decode(bytes.NewReader(input), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts := opts
+				opts.AutoOrient = true
+				m, err := Decode(bytes.NewReader(input), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if o == 1 {
+					if err := equalImages(m, plain); err != nil {
+						t.Errorf("%s %+v orientation %d: %v", filename, opts, o, err)
+					}
+					continue
+				}
+				pb := plain.Bounds()
+				w, h := pb.Dx(), pb.Dy()
+				want := image.NewRGBA(image.Rect(0, 0, w, h))
+				if transposes(o) {
+					want = image.NewRGBA(image.Rect(0, 0, h, w))
+				}
+				for y := 0; y < want.Rect.Dy(); y++ {
+					for x := 0; x < want.Rect.Dx(); x++ {
+						sx, sy := orientSource(o, x, y, w, h)
+						want.Set(x, y, plain.At(pb.Min.X+sx, pb.Min.Y+sy))
+					}
+				}
+				if m.Bounds() != want.Rect {
+					t.Errorf("%s %+v orientation %d: got bounds %v, want %v", filename, opts, o, m.Bounds(), want.Rect)
+					continue
+				}
+				if err := equalImages(toRGBA(m), want); err != nil {
+					t.Errorf("%s %+v orientation %d: %v", filename, opts, o, err)
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeAutoOrientFitTo tests that FitTo is the box of the oriented
+// image.
+func TestDecodeAutoOrientFitTo(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := insertSegment(data, exifSegment(binary.LittleEndian, 6, nil))
+	box := image.Pt(52, 75)
+	m, err := Decode(bytes.NewReader(input), DecodeOptions{FitTo: box, Resample: true, AutoOrient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The oriented image is 103x150, which fits 52x75 exactly at half size.
+	if got, want := m.Bounds(), image.Rect(0, 0, 52, 75); got != want {
+		t.Errorf("got bounds %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// decodeMCUsConcurrently decodes the MCUs of a sequential scan that has
+// restart markers on up to d.concurrency goroutines. The restart intervals
+// are independent of each other, as the bit reader and the DC predictors are
+// reset at every RST marker, and they write to disjoint blocks of the image.
+//
+// The entropy-coded data is read into memory first. If it is truncated or
+// the number of RST markers is not what the restart interval implies, the
+// scan is decoded sequentially instead, so that corrupt images are handled
+// the same way as without concurrency.
+func (d *decoder) decodeMCUsConcurrently(sh *scanHeader, mxx, total int) error {
+	data, err := d.readScanData()
+	starts := restartOffsets(data)
+	n := (total + d.ri - 1) / d.ri
+	if err != nil || len(starts) != n {
+		d.unread(data)
+		return d.decodeMCUs(sh, mxx, 0, total)
+	}
+
+	err = d.forEach(n, func() func(k int) error {
+		c := new(decoder)
+		*c = *d
+		return func(k int) error {
+			end := len(data)
+			if k+1 < n {
+				end = starts[k+1]
+			}
+			// Each segment's data includes the marker that follows it, as
+			// Huffman decoding may read a little past its end.
+			c.r = bytes.NewReader(data[starts[k]:end])
+			c.bytes.i, c.bytes.j, c.bytes.nUnreadable = 0, 0, 0
+			c.eobRun = 0
+			return c.decodeMCUs(sh, mxx, k*d.ri, min((k+1)*d.ri, total))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	// Give back the marker that ends the scan.
+	d.unread(data[len(data)-2:])
+	return nil
+}
+
+// readScanData reads the entropy-coded data of a scan, up to and including
+// the marker that follows it. Byte-stuffed 0xff bytes and RST markers are
+// part of the data. On error, it returns the data read so far.
+func (d *decoder) readScanData() ([]byte, error) {
+	d.bytes.nUnreadable = 0
+	var data []byte
+	for {
+		if d.bytes.i == d.bytes.j {
+			if err := d.fill(); err != nil {
+				return data, err
+			}
+		}
+		buf := d.bytes.buf[d.bytes.i:d.bytes.j]
+		i := bytes.IndexByte(buf, 0xff)
+		if i < 0 {
+			data = append(data, buf...)
+			d.bytes.i = d.bytes.j
+			continue
+		}
+		data = append(data, buf[:i+1]...)
+		d.bytes.i += i + 1
+
+		// Section B.1.1.2 says that any marker may be preceded by fill bytes.
+		x := byte(0xff)
+		for x == 0xff {
+			var err error
+			if x, err = d.readByte(); err != nil {
+				return data, err
+			}
+			data = append(data, x)
+		}
+		if x != 0x00 && (x < rst0Marker || rst7Marker < x) {
+			return data, nil
+		}
+	}
+}
+
+// restartOffsets returns the offsets in data, as returned by readScanData,
+// at which restart intervals start. The first interval starts at 0, the
+// others just past an RST marker.
+func restartOffsets(data []byte) []int {
+	starts := []int{0}
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] != 0xff {
+			continue
+		}
+		if x := data[i+1]; rst0Marker <= x && x <= rst7Marker {
+			starts = append(starts, i+2)
+			i++
+		}
+	}
+	return starts
+}
+
+// unread gives p back to the input, to be read again before the bytes
+// buffered in d.bytes and the rest of d.r.
+func (d *decoder) unread(p []byte) {
+	rest := append(p[:len(p):len(p)], d.bytes.buf[d.bytes.i:d.bytes.j]...)
+	d.r = io.MultiReader(bytes.NewReader(rest), d.r)
+	d.bytes.i, d.bytes.j, d.bytes.nUnreadable = 0, 0, 0
+}
+
+// forEach calls a task for every k in [0, n), on up to d.concurrency
+// goroutines. newTask is called once per goroutine and returns the function
+// that it runs, so that the function can keep state private to its
+// goroutine. forEach returns the error of the task with the lowest k that
+// failed, after all tasks have finished.
+func (d *decoder) forEach(n int, newTask func() func(k int) error) error {
+	if d.concurrency <= 1 || n <= 1 {
+		task := newTask()
+		for k := 0; k < n; k++ {
+			if err := task(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	var (
+		next atomic.Int32
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < min(d.concurrency, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task := newTask()
+			for {
+				k := int(next.Add(1)) - 1
+				if k >= n {
+					return
+				}
+				errs[k] = task(k)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+// TestDecodeConcurrency tests that decoding restart intervals, and
+// reconstructing progressive images, concurrently gives the same image as
+// doing so sequentially.
+func TestDecodeConcurrency(t *testing.T) {
+	for _, filename := range []string{
+		"testdata/video-001.jpeg",
+		"testdata/video-001.restart2.jpeg",
+		"testdata/video-001.rst3.jpeg",
+		"testdata/video-005.gray.rst5.jpeg",
+		"testdata/video-001.progressive.jpeg",
+		"testdata/video-001.q50.410.progressive.jpeg",
+		"testdata/video-005.gray.q50.2x2.progressive.jpeg",
+	} {
+		for _, opts := range []DecodeOptions{
+			{},
+			{DCTSizeScaled: 3},
+			{DCTSizeScaled: 16, FullChroma: true},
+			{Crop: image.Rect(30, 20, 90, 60)},
+		} {
+			want, err := decodeFileWithOptions(filename, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.Concurrency = 4
+			m, err := decodeFileWithOptions(filename, opts)
+			if err != nil {
+				t.Errorf("%s %+v: %v", filename, opts, err)
+				continue
+			}
+			if err := equalImages(m, want); err != nil {
+				t.Errorf("%s %+v: %v", filename, opts, err)
+			}
+		}
+	}
+}
+
+// TestDecodeConcurrencyTruncated tests that a truncated image with restart
+// markers is decoded as far as it would be sequentially.
+func TestDecodeConcurrencyTruncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.rst3.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:len(data)*2/3]
+	if _, err := Decode(bytes.NewReader(data), DecodeOptions{Concurrency: 4}); err == nil {
+		t.Error("got nil error")
+	}
+	want, err := Decode(bytes.NewReader(data), DecodeOptions{Tolerant: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(bytes.NewReader(data), DecodeOptions{Tolerant: true, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestartOffsets(t *testing.T) {
+	data := []byte{1, 0xff, 0x00, 2, 0xff, 0xd0, 3, 0xff, 0xff, 0xd1, 0xff, 0xd9}
+	got := restartOffsets(data)
+	want := []int{0, 6, 10}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
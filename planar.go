@@ -0,0 +1,125 @@
+package jpegscaled
+
+import (
+	"image"
+	"image/color"
+)
+
+// Planar is an image whose components are each stored in a plane of their
+// own, at the resolution of their sampling factors. Decode returns it for
+// images with 2 or more than 4 components, such as multispectral images or
+// images with an alpha channel, whose color model is unknown: interpreting
+// the components is left to the caller. The planes are in the order of the
+// frame header.
+//
+// As an image.Image, a Planar reports the samples of its first component as
+// gray. AutoOrient, Resample and ConvertToSRGB leave it unchanged.
+type Planar struct {
+	Planes []Plane
+	// HMax and VMax are the largest sampling factors of the components. Rect
+	// is at the resolution of a component with those factors.
+	HMax, VMax int
+	Rect       image.Rectangle
+}
+
+// Plane holds the samples of one component of a Planar image.
+type Plane struct {
+	// ID is the component identifier, and H and V its sampling factors.
+	ID   uint8
+	H, V int
+	// Pix holds the samples, starting at Rect.Min, with Stride bytes per row.
+	// Rect is in the plane's own coordinates: the sample covering the pixel
+	// (x, y) of the image is at (x * H / HMax, y * V / VMax).
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+}
+
+// ColorModel returns color.GrayModel, as At reports the first component.
+func (p *Planar) ColorModel() color.Model { return color.GrayModel }
+
+func (p *Planar) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the sample of the first component at (x, y), as gray.
+func (p *Planar) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.Gray{}
+	}
+	return color.Gray{p.SampleAt(0, x, y)}
+}
+
+// SampleAt returns the sample of component i that covers the pixel (x, y)
+// of the image. It returns 0 if (x, y) is outside the image.
+func (p *Planar) SampleAt(i, x, y int) uint8 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	pl := &p.Planes[i]
+	sx, sy := x*pl.H/p.HMax, y*pl.V/p.VMax
+	return pl.Pix[(sy-pl.Rect.Min.Y)*pl.Stride+sx-pl.Rect.Min.X]
+}
+
+// planeRect returns the rectangle of a plane with sampling factors h and v
+// that covers r, in the plane's coordinates.
+func (p *Planar) planeRect(r image.Rectangle, h, v int) image.Rectangle {
+	return image.Rect(
+		r.Min.X*h/p.HMax, r.Min.Y*v/p.VMax,
+		(r.Max.X*h+p.HMax-1)/p.HMax, (r.Max.Y*v+p.VMax-1)/p.VMax,
+	)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares samples with the original image.
+func (p *Planar) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	sub := &Planar{
+		Planes: make([]Plane, len(p.Planes)),
+		HMax:   p.HMax,
+		VMax:   p.VMax,
+		Rect:   r,
+	}
+	// If r is empty, so are the planes, as for the image types of the
+	// standard library.
+	for i, pl := range p.Planes {
+		sub.Planes[i] = Plane{ID: pl.ID, H: pl.H, V: pl.V, Stride: pl.Stride}
+		if r.Empty() {
+			continue
+		}
+		pr := p.planeRect(r, pl.H, pl.V).Intersect(pl.Rect)
+		sub.Planes[i].Rect = pr
+		sub.Planes[i].Pix = pl.Pix[(pr.Min.Y-pl.Rect.Min.Y)*pl.Stride+pr.Min.X-pl.Rect.Min.X:]
+	}
+	return sub
+}
+
+// makePlanar makes the Planar image of an image whose number of components
+// has no other image type. Its planes are those that the components are
+// decoded into, which cover the MCUs of r.
+func (d *decoder) makePlanar(r, visible image.Rectangle) error {
+	if d.precision > 8 {
+		return UnsupportedError("precision above 8 bits with 2 or more than 4 components")
+	}
+	// Planes keep the sampling of their components, so FullChroma, which
+	// would decode subsampled ones at the luma resolution, does not apply.
+	d.fullChroma = false
+	d.makePlanes()
+	m := &Planar{
+		Planes: make([]Plane, d.nComp),
+		HMax:   d.hMax,
+		VMax:   d.vMax,
+		Rect:   r,
+	}
+	for i := range m.Planes {
+		c := &d.comp[i]
+		m.Planes[i] = Plane{
+			ID:     c.c,
+			H:      c.h,
+			V:      c.v,
+			Pix:    d.planes[i],
+			Stride: d.planeStride[i],
+			Rect:   m.planeRect(r, c.h, c.v),
+		}
+	}
+	d.imgPlanar = m.SubImage(visible).(*Planar)
+	return nil
+}
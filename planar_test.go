@@ -0,0 +1,205 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// planarTests are 2-component images, with golden images decoded by libjpeg
+// without fancy upsampling, whose planes are stacked vertically.
+var planarTests = []imageTest{
+	{"testdata/video-001.q50.planar2", "testdata/video-001.q50.planar2.jpeg"},
+	{"testdata/video-001.q50.planar2", "testdata/video-001.q50.planar2.progressive.jpeg"},
+	// Every component has a scan of its own.
+	{"testdata/video-001.q50.planar2", "testdata/video-001.q50.planar2.separate.jpeg"},
+}
+
+// TestDecodePlanar tests that 2-component images decode to Planar images
+// that match libjpeg at full size, and stay close to it when scaled.
+func TestDecodePlanar(t *testing.T) {
+	for _, it := range planarTests {
+		m, err := decodeStd(it.goldenFilename + "#8.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := m.(*image.Gray)
+		w, h := g.Bounds().Dx(), g.Bounds().Dy()/2
+		for _, size := range []int{8, 4, 2, 1} {
+			m, err := decodeJpegScaled(it.filename, size)
+			if err != nil {
+				t.Errorf("%s #%d: %v", it.filename, size, err)
+				continue
+			}
+			p, ok := m.(*Planar)
+			if !ok {
+				t.Errorf("%s #%d: got %T, want *Planar", it.filename, size, m)
+				continue
+			}
+			want := image.Rect(0, 0, w*size/DCTSIZE, h*size/DCTSIZE)
+			if p.Bounds() != want || len(p.Planes) != 2 {
+				t.Errorf("%s #%d: got bounds %v and %d planes, want %v and 2", it.filename, size, p.Bounds(), len(p.Planes), want)
+				continue
+			}
+			if p.Planes[0].H != 2 || p.Planes[0].V != 2 || p.Planes[1].H != 1 || p.Planes[1].V != 1 {
+				t.Errorf("%s #%d: got sampling factors %+v", it.filename, size, p.Planes)
+			}
+			for i, pl := range p.Planes {
+				// Compare every sample of the plane with the average of the
+				// golden samples of the pixels that it covers.
+				kx, ky := DCTSIZE/size*p.HMax/pl.H, DCTSIZE/size*p.VMax/pl.V
+				sum, n := 0, 0
+				for y := 0; y < pl.Rect.Dy(); y++ {
+					for x := 0; x < pl.Rect.Dx(); x++ {
+						avg := 0
+						for sy := y * ky; sy < y*ky+ky; sy++ {
+							for sx := x * kx; sx < x*kx+kx; sx++ {
+								avg += int(g.GrayAt(min(sx, w-1), min(sy, h-1)+i*h).Y)
+							}
+						}
+						d := int(pl.Pix[y*pl.Stride+x]) - avg/(kx*ky)
+						sum += max(d, -d)
+						n++
+					}
+				}
+				if size == 8 && sum != 0 {
+					t.Errorf("%s: plane %d differs from libjpeg", it.filename, i)
+				} else if diff := float64(sum) / float64(n); diff > 6 {
+					t.Errorf("%s #%d: plane %d: mean difference %.1f", it.filename, size, i, diff)
+				}
+			}
+		}
+	}
+}
+
+// TestDecodePlanarFullChroma tests that FullChroma does not change Planar
+// images, whose planes keep the sampling of their components.
+func TestDecodePlanarFullChroma(t *testing.T) {
+	for _, it := range planarTests {
+		data, err := os.ReadFile(it.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range []DecodeOptions{
+			{DCTSizeScaled: 1},
+			{DCTSizeScaled: 2},
+			{DCTSizeScaled: 8},
+			{DCTSizeScaled: 16},
+			{DCTSizeScaledX: 16, DCTSizeScaledY: 1},
+		} {
+			want, err := Decode(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.FullChroma = true
+			m, err := Decode(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Errorf("%s %+v: %v", it.filename, opts, err)
+				continue
+			}
+			p, w := m.(*Planar), want.(*Planar)
+			if p.Bounds() != w.Bounds() || len(p.Planes) != len(w.Planes) {
+				t.Errorf("%s %+v: got bounds %v and %d planes, want %v and %d", it.filename, opts, p.Bounds(), len(p.Planes), w.Bounds(), len(w.Planes))
+				continue
+			}
+			b := p.Bounds()
+		planes:
+			for i := range p.Planes {
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					for x := b.Min.X; x < b.Max.X; x++ {
+						if got, want := p.SampleAt(i, x, y), w.SampleAt(i, x, y); got != want {
+							t.Errorf("%s %+v: plane %d: (%d, %d): got %d, want %d", it.filename, opts, i, x, y, got, want)
+							break planes
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestDecodePlanarLossless tests that lossless images with 2 and 5
+// components decode to their samples.
+func TestDecodePlanarLossless(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	const w, h = 13, 11
+	for _, nComp := range []int{2, 5} {
+		planes := make([][]uint16, nComp)
+		ids := make([]byte, nComp)
+		for i := range planes {
+			planes[i] = randomPlane(rng, w, h, 8)
+			ids[i] = byte(10 + i)
+		}
+		// Scans have at most 4 components.
+		lp := losslessParams{precision: 8, psv: 4, separate: nComp > 4}
+		data := encodeLossless(planes, w, h, ids, lp)
+
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ColorModel != color.GrayModel || len(cfg.Components) != nComp {
+			t.Errorf("%d components: got config %+v", nComp, cfg)
+		}
+		m, err := Decode(bytes.NewReader(data), DecodeOptions{})
+		if err != nil {
+			t.Fatalf("%d components: %v", nComp, err)
+		}
+		p, ok := m.(*Planar)
+		if !ok || p.Bounds() != image.Rect(0, 0, w, h) || len(p.Planes) != nComp {
+			t.Fatalf("%d components: got %T with bounds %v", nComp, m, m.Bounds())
+		}
+		for i := range planes {
+			if p.Planes[i].ID != ids[i] {
+				t.Errorf("%d components: plane %d: got ID %d, want %d", nComp, i, p.Planes[i].ID, ids[i])
+			}
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					if got, want := p.SampleAt(i, x, y), uint8(planes[i][y*w+x]); got != want {
+						t.Fatalf("%d components: plane %d: (%d, %d): got %d, want %d", nComp, i, x, y, got, want)
+					}
+				}
+			}
+		}
+		if got, want := m.At(1, 2), (color.Gray{uint8(planes[0][2*w+1])}); got != want {
+			t.Errorf("%d components: At(1, 2) = %v, want %v", nComp, got, want)
+		}
+	}
+}
+
+// TestDecodePlanarCrop tests that cropped and scaled Planar images have the
+// samples of the whole image.
+func TestDecodePlanarCrop(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.q50.planar2.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := Decode(bytes.NewReader(data), DecodeOptions{DCTSizeScaled: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(bytes.NewReader(data), DecodeOptions{DCTSizeScaled: 4, Crop: image.Rect(37, 21, 101, 90)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, f := m.(*Planar), full.(*Planar)
+	if want := image.Rect(18, 10, 51, 45); p.Bounds() != want {
+		t.Fatalf("got bounds %v, want %v", p.Bounds(), want)
+	}
+	b := p.Bounds()
+	for i := range p.Planes {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if got, want := p.SampleAt(i, x, y), f.SampleAt(i, x, y); got != want {
+					t.Fatalf("plane %d: (%d, %d): got %d, want %d", i, x, y, got, want)
+				}
+			}
+		}
+	}
+	if sub := p.SubImage(image.Rect(0, 0, 5, 5)); !sub.Bounds().Empty() {
+		t.Errorf("got sub-image bounds %v, want empty", sub.Bounds())
+	}
+}
@@ -0,0 +1,366 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// bitWriter writes Huffman-coded data with byte stuffing.
+type bitWriter struct {
+	buf   []byte
+	acc   uint32
+	nBits uint
+}
+
+func (w *bitWriter) write(bits uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | bits>>uint(i)&1
+		w.nBits++
+		if w.nBits == 8 {
+			w.buf = append(w.buf, byte(w.acc))
+			if byte(w.acc) == 0xff {
+				w.buf = append(w.buf, 0x00)
+			}
+			w.acc, w.nBits = 0, 0
+		}
+	}
+}
+
+// flush pads the last byte with 1 bits.
+func (w *bitWriter) flush() {
+	if w.nBits > 0 {
+		w.write(1<<(8-w.nBits)-1, 8-w.nBits)
+	}
+}
+
+// writeValue writes the Huffman code of symbol, followed by the s low bits
+// of the value v of category s, as per section F.1.2.1.
+func (w *bitWriter) writeValue(symbol byte, s uint, v int32, codes map[byte]uint32, codeLen uint) {
+	w.write(codes[symbol], codeLen)
+	if v < 0 {
+		v--
+	}
+	w.write(uint32(v)&(1<<s-1), s)
+}
+
+// category returns the number of bits of the magnitude of v.
+func category(v int32) uint {
+	if v < 0 {
+		v = -v
+	}
+	s := uint(0)
+	for ; v != 0; v >>= 1 {
+		s++
+	}
+	return s
+}
+
+// encode12 encodes 12-bit planes, all w x h samples with w and h multiples
+// of 8, with quantization values of 1, as an extended sequential (SOF1) or
+// progressive (SOF2) JPEG image whose components have the given
+// identifiers. Progressive images have an interleaved DC scan followed by a
+// full AC scan per component. The Huffman tables code every DC category up to
+// 15 with 5 bits and every AC symbol with 8 bits.
+func encode12(planes [][]uint16, w, h int, ids []byte, progressive bool) []byte {
+	segment := func(data []byte, marker byte, payload []byte) []byte {
+		data = append(data, 0xff, marker)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(payload)+2))
+		return append(data, payload...)
+	}
+	data := []byte{0xff, soiMarker}
+
+	dqt := []byte{0}
+	for i := 0; i < blockSize; i++ {
+		dqt = append(dqt, 1)
+	}
+	data = segment(data, dqtMarker, dqt)
+
+	sof := []byte{12, byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(len(planes))}
+	for _, id := range ids {
+		sof = append(sof, id, 0x11, 0)
+	}
+	marker := byte(sof1Marker)
+	if progressive {
+		marker = sof2Marker
+	}
+	data = segment(data, marker, sof)
+
+	dcCodes, acCodes := map[byte]uint32{}, map[byte]uint32{}
+	dht := []byte{0x00, 0, 0, 0, 0, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for s := byte(0); s < 16; s++ {
+		dcCodes[s] = uint32(s)
+		dht = append(dht, s)
+	}
+	acSymbols := []byte{0x00, 0xf0}
+	for r := byte(0); r < 16; r++ {
+		for s := byte(1); s <= 14; s++ {
+			acSymbols = append(acSymbols, r<<4|s)
+		}
+	}
+	dht = append(dht, 0x10, 0, 0, 0, 0, 0, 0, 0, byte(len(acSymbols)), 0, 0, 0, 0, 0, 0, 0, 0)
+	for i, s := range acSymbols {
+		acCodes[s] = uint32(i)
+		dht = append(dht, s)
+	}
+	data = segment(data, dhtMarker, dht)
+
+	// Compute the quantized coefficients of every block, in zig-zag order.
+	bw, bh := w/8, h/8
+	coeffs := make([][][blockSize]int32, len(planes))
+	for c, plane := range planes {
+		coeffs[c] = make([][blockSize]int32, bw*bh)
+		for b := range coeffs[c] {
+			x0, y0 := 8*(b%bw), 8*(b/bw)
+			for zig := 0; zig < blockSize; zig++ {
+				u, v := unzig[zig]%8, unzig[zig]/8
+				sum := 0.0
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						sum += (float64(plane[(y0+y)*w+x0+x]) - 2048) *
+							math.Cos(float64((2*x+1)*u)*math.Pi/16) *
+							math.Cos(float64((2*y+1)*v)*math.Pi/16)
+					}
+				}
+				coeffs[c][b][zig] = int32(math.Round(alpha(u) * alpha(v) * sum / 8))
+			}
+		}
+	}
+
+	writeAC := func(bits *bitWriter, b *[blockSize]int32) {
+		run := 0
+		for zig := 1; zig < blockSize; zig++ {
+			v := b[zig]
+			if v == 0 {
+				run++
+				continue
+			}
+			for ; run >= 16; run -= 16 {
+				bits.write(acCodes[0xf0], 8)
+			}
+			s := category(v)
+			bits.writeValue(byte(run<<4)|byte(s), s, v, acCodes, 8)
+			run = 0
+		}
+		if run > 0 {
+			bits.write(acCodes[0x00], 8)
+		}
+	}
+	scan := func(comps []int, ss, se byte) {
+		sos := []byte{byte(len(comps))}
+		for _, c := range comps {
+			sos = append(sos, ids[c], 0x00)
+		}
+		data = segment(data, sosMarker, append(sos, ss, se, 0))
+		var bits bitWriter
+		preds := make([]int32, len(planes))
+		for b := 0; b < bw*bh; b++ {
+			for _, c := range comps {
+				blk := &coeffs[c][b]
+				if ss == 0 {
+					diff := blk[0] - preds[c]
+					preds[c] = blk[0]
+					s := category(diff)
+					bits.writeValue(byte(s), s, diff, dcCodes, 5)
+				}
+				if se != 0 {
+					writeAC(&bits, blk)
+				}
+			}
+		}
+		bits.flush()
+		data = append(data, bits.buf...)
+	}
+	all := make([]int, len(planes))
+	for i := range all {
+		all[i] = i
+	}
+	if progressive {
+		scan(all, 0, 0)
+		for c := range planes {
+			scan([]int{c}, 1, 63)
+		}
+	} else {
+		scan(all, 0, 63)
+	}
+	return append(data, 0xff, eoiMarker)
+}
+
+// testPlane12 returns a smooth w x h plane of 12-bit samples, offset by
+// phase.
+func testPlane12(w, h int, phase float64) []uint16 {
+	p := make([]uint16, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 2048 + 1800*math.Sin(float64(x)/6+phase)*math.Cos(float64(y)/5)
+			p[y*w+x] = uint16(math.Round(v))
+		}
+	}
+	return p
+}
+
+// scale12 scales a 12-bit sample to 16 bits as the decoder does.
+func scale12(v uint16) uint16 {
+	return v<<4 | v>>8
+}
+
+// TestDecode12Bit tests that 12-bit gray and RGB images, sequential and
+// progressive, decode to 16-bit images close to their samples.
+func TestDecode12Bit(t *testing.T) {
+	const w, h = 24, 16
+	// The samples are within a few 12-bit levels of the source after the
+	// round trip through the DCT.
+	const tolerance = 3 << 4
+	for _, progressive := range []bool{false, true} {
+		for _, nComp := range []int{1, 3} {
+			planes := make([][]uint16, nComp)
+			for i := range planes {
+				planes[i] = testPlane12(w, h, float64(i))
+			}
+			ids := []byte{1}
+			if nComp == 3 {
+				ids = []byte{'R', 'G', 'B'}
+			}
+			data := encode12(planes, w, h, ids, progressive)
+
+			cfg, err := DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantModel := color.Model(color.Gray16Model)
+			if nComp == 3 {
+				wantModel = color.RGBA64Model
+			}
+			wantType := JpegTypeExtended
+			if progressive {
+				wantType = JpegTypeProgressive
+			}
+			if cfg.Precision != 12 || cfg.ColorModel != wantModel || cfg.JpegType != wantType {
+				t.Errorf("progressive %t, %d components: got precision %d, model %v, type %d", progressive, nComp, cfg.Precision, cfg.ColorModel, cfg.JpegType)
+			}
+
+			m, err := Decode(bytes.NewReader(data), DecodeOptions{})
+			if err != nil {
+				t.Fatalf("progressive %t, %d components: %v", progressive, nComp, err)
+			}
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					var got []uint16
+					switch m := m.(type) {
+					case *image.Gray16:
+						got = []uint16{m.Gray16At(x, y).Y}
+					case *image.RGBA64:
+						c := m.RGBA64At(x, y)
+						got = []uint16{c.R, c.G, c.B}
+					default:
+						t.Fatalf("progressive %t, %d components: got %T", progressive, nComp, m)
+					}
+					for i, g := range got {
+						want := scale12(planes[i][y*w+x])
+						if d := int(g) - int(want); d < -tolerance || d > tolerance {
+							t.Fatalf("progressive %t, %d components: (%d, %d) channel %d: got %d, want %d", progressive, nComp, x, y, i, g, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestDecode12BitYCbCr tests the conversion of 12-bit YCbCr images to RGB.
+func TestDecode12BitYCbCr(t *testing.T) {
+	const w, h = 16, 16
+	y := testPlane12(w, h, 0)
+	neutral := make([]uint16, w*h)
+	red := make([]uint16, w*h)
+	for i := range neutral {
+		neutral[i] = 2048
+		red[i] = 4095
+	}
+	for _, tc := range []struct {
+		cb, cr []uint16
+		want   func(y uint8) (r, g, b uint8)
+	}{
+		{neutral, neutral, func(y uint8) (uint8, uint8, uint8) { return y, y, y }},
+		{neutral, red, func(y uint8) (uint8, uint8, uint8) { return color.YCbCrToRGB(y, 128, 255) }},
+	} {
+		data := encode12([][]uint16{y, tc.cb, tc.cr}, w, h, []byte{1, 2, 3}, false)
+		m, err := Decode(bytes.NewReader(data), DecodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rgba, ok := m.(*image.RGBA64)
+		if !ok {
+			t.Fatalf("got %T, want *image.RGBA64", m)
+		}
+		for i := range y {
+			c := rgba.RGBA64At(i%w, i/w)
+			r, g, b := tc.want(uint8(y[i] >> 4))
+			for k, pair := range [][2]uint16{{c.R, uint16(r)}, {c.G, uint16(g)}, {c.B, uint16(b)}} {
+				if d := int(pair[0]>>8) - int(pair[1]); d < -2 || d > 2 {
+					t.Fatalf("sample %d, channel %d: got %#04x, want about %#02x", i, k, pair[0], pair[1])
+				}
+			}
+		}
+	}
+}
+
+// TestDecode12BitScaled tests scaled decoding, resampling and orienting of
+// 12-bit images.
+func TestDecode12BitScaled(t *testing.T) {
+	const w, h = 24, 16
+	src := testPlane12(w, h, 0)
+	data := encode12([][]uint16{src}, w, h, []byte{1}, true)
+	m, err := Decode(bytes.NewReader(data), DecodeOptions{DCTSizeScaled: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := m.(*image.Gray16)
+	if !ok || g.Bounds() != image.Rect(0, 0, w/2, h/2) {
+		t.Fatalf("got %T with bounds %v", m, m.Bounds())
+	}
+	for y := 0; y < h/2; y++ {
+		for x := 0; x < w/2; x++ {
+			// The 4x4 IDCT approximates the mean of the 2x2 source samples.
+			sum := 0
+			for _, p := range [4]int{0, 1, w, w + 1} {
+				sum += int(scale12(src[2*y*w+2*x+p]))
+			}
+			if d := int(g.Gray16At(x, y).Y) - sum/4; d < -1000 || d > 1000 {
+				t.Errorf("(%d, %d): got %d, want about %d", x, y, g.Gray16At(x, y).Y, sum/4)
+			}
+		}
+	}
+
+	m, err = Decode(bytes.NewReader(data), DecodeOptions{FitTo: image.Pt(10, 10), Resample: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*image.Gray16); !ok || m.Bounds() != image.Rect(0, 0, 10, 7) {
+		t.Errorf("resampled: got %T with bounds %v", m, m.Bounds())
+	}
+
+	oriented := orient(g, 6)
+	o, ok := oriented.(*image.Gray16)
+	if !ok || o.Bounds() != image.Rect(0, 0, h/2, w/2) {
+		t.Fatalf("oriented: got %T with bounds %v", oriented, oriented.Bounds())
+	}
+	if o.Gray16At(h/2-1, 0) != g.Gray16At(0, 0) {
+		t.Errorf("oriented: top-right is %v, want %v", o.Gray16At(h/2-1, 0), g.Gray16At(0, 0))
+	}
+}
+
+// TestDecode12BitBaseline tests that 12-bit precision is rejected in
+// baseline images, which only allow 8 bits.
+func TestDecode12BitBaseline(t *testing.T) {
+	data := encode12([][]uint16{testPlane12(8, 8, 0)}, 8, 8, []byte{1}, false)
+	i := bytes.Index(data, []byte{0xff, sof1Marker})
+	data[i+1] = sof0Marker
+	_, err := Decode(bytes.NewReader(data), DecodeOptions{})
+	if err != UnsupportedError("precision") {
+		t.Errorf("got %v, want %v", err, UnsupportedError("precision"))
+	}
+}
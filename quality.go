@@ -0,0 +1,116 @@
+package jpegscaled
+
+import "sort"
+
+// standardQuant are the luminance and chrominance quantization tables of
+// section K.1, in zig-zag order. The IJG encoder scales them by its quality
+// setting.
+var standardQuant = [2]block{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// ijgScale returns the percentage by which the IJG encoder scales the
+// standard tables for quality q, as jpeg_quality_scaling does.
+func ijgScale(q int) int {
+	if q < 50 {
+		return 5000 / q
+	}
+	return 200 - 2*q
+}
+
+// scaledQuant reports whether table is the standard table std scaled by
+// scale, as jpeg_add_quant_table does. Values are clamped to 255 if every
+// value of table fits in 8 bits, as for baseline images, and to 32767
+// otherwise.
+func scaledQuant(table, std *block, scale int) bool {
+	limit := int32(255)
+	for _, v := range table {
+		if v > 255 {
+			limit = 32767
+		}
+	}
+	for i, s := range std {
+		v := min(max((s*int32(scale)+50)/100, 1), limit)
+		if table[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// quality estimates the IJG quality setting, from 1 to 100, that the image's
+// quantization tables were made with, and reports whether they are exactly
+// the standard tables scaled for it. The first component's table is
+// compared with the standard luminance table, and for YCbCr images, the
+// second component's with the chrominance table. It returns 0 if those
+// tables are undefined.
+func (d *decoder) quality() (q int, standard bool) {
+	if d.nComp == 0 {
+		return 0, false
+	}
+	var tables [2]*block
+	n := 1
+	if d.nComp == 3 && !d.isRGB() {
+		n = 2
+	}
+	for i := 0; i < n; i++ {
+		tables[i] = &d.quant[d.comp[i].tq]
+		if tables[i][0] == 0 {
+			// Quantization values are at least 1, so the table is undefined.
+			return 0, false
+		}
+	}
+
+	for q := 1; q <= 100; q++ {
+		match := true
+		for i := 0; i < n && match; i++ {
+			match = scaledQuant(tables[i], &standardQuant[i], ijgScale(q))
+		}
+		if match {
+			return q, true
+		}
+	}
+
+	// Estimate the scale as the median ratio of the tables' values to the
+	// standard values, ignoring values that may have been clamped, and
+	// invert ijgScale.
+	var ratios []float64
+	for i := 0; i < n; i++ {
+		for k, v := range tables[i] {
+			if v < 255 {
+				ratios = append(ratios, float64(100*v)/float64(standardQuant[i][k]))
+			}
+		}
+	}
+	if len(ratios) == 0 {
+		return 1, false
+	}
+	sort.Float64s(ratios)
+	scale := ratios[len(ratios)/2]
+	var est float64
+	if scale <= 100 {
+		est = (200 - scale) / 2
+	} else {
+		est = 5000 / scale
+	}
+	return min(max(int(est+0.5), 1), 100), false
+}
@@ -0,0 +1,55 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// TestQuality tests the quality estimated for images encoded by the standard
+// library, which scales the standard tables as the IJG encoder does.
+func TestQuality(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio420)
+	gray := image.NewGray(src.Rect)
+	for _, m := range []image.Image{src, gray} {
+		for _, q := range []int{1, 10, 49, 50, 51, 75, 90, 99, 100} {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, m, &jpeg.Options{Quality: q}); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := q
+			if q < 3 {
+				// Below 3, every value is clamped to 255.
+				want = 1
+			}
+			if cfg.Quality != want || !cfg.StandardQuant {
+				t.Errorf("%T quality %d: got %d, %t, want %d, true", m, q, cfg.Quality, cfg.StandardQuant, want)
+			}
+
+			// A custom table is estimated from the magnitude of its values,
+			// which says little when they are all clamped.
+			if q < 3 {
+				continue
+			}
+			data := buf.Bytes()
+			dqt := bytes.Index(data, []byte{0xff, dqtMarker})
+			if v := &data[dqt+5+10]; *v > 1 {
+				*v--
+			} else {
+				*v++
+			}
+			cfg, err = DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.StandardQuant || cfg.Quality < want-2 || cfg.Quality > want+2 {
+				t.Errorf("%T quality %d, custom table: got %d, %t, want about %d, false", m, q, cfg.Quality, cfg.StandardQuant, want)
+			}
+		}
+	}
+}
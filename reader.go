@@ -22,13 +22,96 @@ const (
 	JpegTypeUnsupported JpegType = 0
 	JpegTypeBaseline    JpegType = 1
 	JpegTypeProgressive JpegType = 2
+	JpegTypeLossless    JpegType = 3
+	JpegTypeExtended    JpegType = 4 // Extended sequential DCT.
 )
 
 type Config struct {
 	image.Config
 	JpegType JpegType
+	// Arithmetic is whether the image is arithmetic coded rather than
+	// Huffman coded.
+	Arithmetic bool
+	// Orientation is the EXIF orientation tag, from 1 to 8, or 0 if the
+	// image has none. Width and Height are those of the image as stored;
+	// orientations 5 to 8 swap them when the image is displayed.
+	Orientation int
+	// ICCProfile is the ICC colour profile reassembled from the image's
+	// APP2 segments, or nil if it has none or its chunks are inconsistent.
+	ICCProfile []byte
+	// Components are the image's components, in frame header order.
+	Components []ComponentInfo
+	// SubsampleRatio is the chroma subsampling of a 3-component image whose
+	// sampling factors have an image.YCbCrSubsampleRatio. It is meaningless
+	// for other images, which are decoded with upsampled chroma.
+	SubsampleRatio image.YCbCrSubsampleRatio
+	// Adobe is whether the image has an Adobe APP14 segment, and
+	// AdobeTransform is the colour transform it gives: 0 for none (RGB or
+	// CMYK), 1 for YCbCr and 2 for YCCK.
+	Adobe          bool
+	AdobeTransform uint8
+	// JFIF is whether the image has a JFIF APP0 segment. If so, JFIFVersion
+	// is its version, as major<<8 | minor, and DensityUnit, XDensity and
+	// YDensity are the pixel density it gives.
+	JFIF               bool
+	JFIFVersion        uint16
+	DensityUnit        DensityUnit
+	XDensity, YDensity int
+	// JFIFThumbnail is the thumbnail of the JFIF APP0 segment, or failing
+	// that, of a JFXX extension APP0 segment, or nil. RGB thumbnails are
+	// returned as *image.RGBA and palette thumbnails as *image.Paletted.
+	// JFIFThumbnailJPEG is instead the data of a JFXX thumbnail coded using
+	// JPEG, which is not decoded: DecodeEmbeddedThumbnail or Decode decode
+	// it.
+	JFIFThumbnail     image.Image
+	JFIFThumbnailJPEG []byte
+	// RestartInterval is the number of MCUs between restart markers, or 0.
+	RestartInterval int
+	// Precision is the sample precision in bits: 8 or 12 for DCT images, and
+	// 2 to 16 for lossless ones. Images with more than 8 bits are decoded to
+	// *image.Gray16 or *image.RGBA64, and those with fewer to 8-bit images,
+	// with samples scaled to the output's range. The ColorModel of images
+	// with more than 8 bits is the matching 16-bit model.
+	Precision int
+	// Scans is the number of scans in the image, or 0 if it is unknown.
+	// DecodeConfig reads no further than the first scan's header, so it only
+	// knows the number of scans of a sequential image whose first scan
+	// holds every component. DecodeResult reports the number of scans it
+	// read, which is all of them unless decoding stopped early.
+	Scans int
+	// Quality is the IJG quality setting, from 1 to 100, estimated from the
+	// quantization tables, or 0 if they are undefined. StandardQuant is
+	// whether the tables are exactly the standard tables of section K.1
+	// scaled for Quality, as the IJG encoder and most others make them;
+	// otherwise, Quality is the setting whose tables are closest in
+	// magnitude.
+	Quality       int
+	StandardQuant bool
 }
 
+// ComponentInfo describes a component of a JPEG image, as specified in
+// section B.2.2.
+type ComponentInfo struct {
+	// ID is the component identifier, e.g. 1 for Y or 'R' for red.
+	ID byte
+	// H and V are the horizontal and vertical sampling factors. They are
+	// always 1 for single-component images, whose sampling factors have no
+	// effect as per section A.2.
+	H, V int
+}
+
+// DensityUnit is the unit of the pixel density of a JFIF image.
+type DensityUnit uint8
+
+const (
+	// DensityNone means that the density only gives the pixel aspect ratio.
+	DensityNone DensityUnit = 0
+	// DensityInch means dots per inch.
+	DensityInch DensityUnit = 1
+	// DensityCentimeter means dots per centimeter.
+	DensityCentimeter DensityUnit = 2
+)
+
 // A FormatError reports that the input is not a valid JPEG.
 type FormatError string
 
@@ -39,8 +122,6 @@ type UnsupportedError string
 
 func (e UnsupportedError) Error() string { return "unsupported JPEG feature: " + string(e) }
 
-var errUnsupportedSubsamplingRatio = UnsupportedError("luma/chroma subsampling ratio")
-
 // Component specification, specified in section B.2.2.
 type component struct {
 	h  int   // Horizontal sampling factor.
@@ -56,14 +137,22 @@ const (
 	maxTh   = 3
 	maxTq   = 3
 
-	maxComponents = 4
+	// maxComponents is the largest number of components of a scan, as per
+	// section B.2.3, and maxFrameComponents that of a frame, as per section
+	// B.2.2.
+	maxComponents      = 4
+	maxFrameComponents = 255
 )
 
 const (
 	sof0Marker = 0xc0 // Start Of Frame (Baseline Sequential).
 	sof1Marker = 0xc1 // Start Of Frame (Extended Sequential).
 	sof2Marker = 0xc2 // Start Of Frame (Progressive).
+	sof3Marker = 0xc3 // Start Of Frame (Lossless).
 	dhtMarker  = 0xc4 // Define Huffman Table.
+	sof9Marker = 0xc9 // Start Of Frame (Extended Sequential, Arithmetic).
+	sofAMarker = 0xca // Start Of Frame (Progressive, Arithmetic).
+	dacMarker  = 0xcc // Define Arithmetic Conditioning.
 	rst0Marker = 0xd0 // ReSTart (0).
 	rst7Marker = 0xd7 // ReSTart (7).
 	soiMarker  = 0xd8 // Start Of Image.
@@ -76,6 +165,8 @@ const (
 	// but in practice, their use is described at
 	// https://www.sno.phy.queensu.ca/~phil/exiftool/TagNames/JPEG.html
 	app0Marker  = 0xe0
+	app1Marker  = 0xe1
+	app2Marker  = 0xe2
 	app14Marker = 0xee
 	app15Marker = 0xef
 )
@@ -133,12 +224,94 @@ type decoder struct {
 		nUnreadable int
 	}
 	width, height int
-	dctSizeScaled int
+	// dctSizeScaledX and dctSizeScaledY are the horizontal and vertical IDCT
+	// output sizes of a block.
+	dctSizeScaledX, dctSizeScaledY int
+	// fullChroma is whether subsampled components are decoded with a larger
+	// IDCT, producing a 4:4:4 image. It is reset by makeImg if the required
+	// IDCT size is not supported.
+	fullChroma bool
+	// crop is the part of the image to decode, in source coordinates.
+	// cropMCU is crop rounded out to whole MCUs, in units of MCUs, and
+	// cropScaled is crop in the coordinates of the scaled image.
+	crop, cropMCU, cropScaled image.Rectangle
+	// scans is the number of progressive scans decoded so far.
+	scans int
+	// onScan, if non-nil, is called after every progressive scan.
+	onScan func(img image.Image, scanIndex int) bool
+	// stopEarly stops decoding a progressive image once every coefficient
+	// that the scaled IDCT uses is complete.
+	stopEarly bool
+	// concurrency is the maximum number of goroutines decoding a scan or
+	// reconstructing a progressive image.
+	concurrency int
+	// headerOnly stops decoding at the first SOS marker, after processing
+	// all the segments before it.
+	headerOnly bool
+	// appOnly processes only the APP0 and APP1 segments, skipping the other
+	// segments, and stops at the frame header, so that the embedded
+	// thumbnails are found whatever the main image's headers hold.
+	appOnly bool
+	// tileRuns, if non-nil, are the runs of MCUs that the first scan is
+	// limited to, read from tileSrc. See RestartIndex.DecodeTile.
+	tileRuns []tileRun
+	tileSrc  io.ReaderAt
+	// fitTo is the bounding box that the decoded image should fit into. When
+	// non-zero, it determines the IDCT sizes once the frame size is known.
+	fitTo image.Point
+	// exif is the TIFF payload of the first EXIF APP1 segment, and
+	// orientation the EXIF orientation parsed from it, or 0.
+	exif        []byte
+	orientation int
+	// autoOrient is whether the decoded image is transformed for display
+	// according to orientation.
+	autoOrient bool
+	// iccChunks are the chunks of the ICC profile read so far, indexed by
+	// sequence number minus 1. iccInvalid is set if the chunks' sequence
+	// numbers or counts are inconsistent.
+	iccChunks  [][]byte
+	iccInvalid bool
+	// cmykToRGB is whether CMYK and YCCK images are converted to RGB.
+	cmykToRGB bool
+	// precision is the sample precision of the frame.
+	precision int
+	// jfifVersion, densityUnit, xDensity and yDensity are the fields of the
+	// JFIF APP0 segment.
+	jfifVersion        uint16
+	densityUnit        DensityUnit
+	xDensity, yDensity int
+	// jfifThumb is the uncompressed thumbnail of a JFIF or JFXX APP0
+	// segment, and jfxxJPEG the JPEG data of a JFXX one.
+	jfifThumb image.Image
+	jfxxJPEG  []byte
+	// firstScanComps is the number of components of the first scan, read by
+	// DecodeConfig.
+	firstScanComps int
 
 	img1        *image.Gray
 	img3        *image.YCbCr
 	blackPix    []byte
 	blackStride int
+	// samples holds the components of a lossless image, at full
+	// resolution, and pointTransform the point transform of their scans.
+	samples        []*samplePlane
+	pointTransform []int
+	// hMax and vMax are the largest sampling factors of the components.
+	// upsample is set if the sampling factors have no direct layout. Every
+	// component is then decoded into planes, at its own resolution, with
+	// planeStride bytes per row, and image upsamples it to the full
+	// resolution of img3, blackPix or img16.
+	hMax, vMax  int
+	upsample    bool
+	planes      [][]byte
+	planeStride []int
+	// img16 holds the components of a 12-bit image, instead of img1 or
+	// img3, with samples scaled to 16 bits. Chroma is at the resolution it
+	// is decoded at.
+	img16 [3]*image.Gray16
+	// imgPlanar is the image of a frame with 2 or more than 4 components,
+	// whose planes are those of the upsample mode.
+	imgPlanar *Planar
 
 	ri    int // Restart Interval.
 	nComp int
@@ -146,22 +319,39 @@ type decoder struct {
 	// As per section 4.5, there are four modes of operation (selected by the
 	// SOF? markers): sequential DCT, progressive DCT, lossless and
 	// hierarchical, although this implementation does not support the latter
-	// two non-DCT modes. Sequential DCT is further split into baseline and
-	// extended, as per section 4.11.
+	// mode, nor arithmetic-coded lossless images. Sequential DCT is further
+	// split into baseline and extended, as per section 4.11. Extended
+	// sequential and progressive images are Huffman or arithmetic coded.
 	baseline    bool
 	progressive bool
+	lossless    bool
+	arithmetic  bool
 
 	jfif                bool
 	adobeTransformValid bool
 	adobeTransform      uint8
 	eobRun              uint16 // End-of-Band run, specified in section G.1.2.2.
 
-	comp       [maxComponents]component
-	progCoeffs [maxComponents][]block // Saved state between progressive-mode scans.
+	// comp has one element per component of the frame, as do samples,
+	// pointTransform, planes, planeStride, progCoeffs and progBits. They are
+	// allocated by processSOF.
+	comp       []component
+	progCoeffs []*coeffStore // Saved state between progressive-mode scans.
 	huff       [maxTc + 1][maxTh + 1]huffman
+	arith      arithDecoder
 	quant      [maxTq + 1]block // Quantization tables, in zig-zag order.
 	tmp        [2 * blockSize]byte
 
+	// dac holds the arithmetic conditioning values of every DC and AC
+	// conditioning table, as set by DAC segments.
+	dac [maxTc + 1][maxTh + 1]uint8
+
+	// progBits records, for every component and zig-zag index of a
+	// progressive image, 1 plus the successive approximation low bit of the
+	// latest scan that contained the coefficient, or 0 if none has yet. A
+	// value of 1 means that the coefficient is complete.
+	progBits [][blockSize]int8
+
 	// tolerant allows decoding of truncated or slightly malformed images.
 	tolerant bool
 }
@@ -321,23 +511,33 @@ func (d *decoder) processSOF(n int) error {
 	if d.nComp != 0 {
 		return FormatError("multiple SOF markers")
 	}
-	switch n {
-	case 6 + 3*1: // Grayscale image.
-		d.nComp = 1
-	case 6 + 3*3: // YCbCr or RGB image.
-		d.nComp = 3
-	case 6 + 3*4: // YCbCrK or CMYK image.
-		d.nComp = 4
-	default:
-		return UnsupportedError("number of components")
+	// Images have 1 (grayscale), 3 (YCbCr or RGB) or 4 (YCbCrK or CMYK)
+	// components. Other numbers of components are decoded to a Planar image.
+	if n < 6+3*1 || n > 6+3*maxFrameComponents || (n-6)%3 != 0 {
+		return FormatError("SOF has wrong length")
 	}
-	if err := d.readFull(d.tmp[:n]); err != nil {
+	d.nComp = (n - 6) / 3
+	d.comp = make([]component, d.nComp)
+	d.progCoeffs = make([]*coeffStore, d.nComp)
+	d.progBits = make([][blockSize]int8, d.nComp)
+	d.samples = make([]*samplePlane, d.nComp)
+	d.pointTransform = make([]int, d.nComp)
+	d.planes = make([][]byte, d.nComp)
+	d.planeStride = make([]int, d.nComp)
+	if err := d.readFull(d.tmp[:6]); err != nil {
 		return err
 	}
-	// We only support 8-bit precision.
-	if d.tmp[0] != 8 {
+	// We support 8-bit precision, 12-bit precision for extended sequential
+	// and progressive images, and 2 to 16-bit precision for lossless images,
+	// as per section B.2.2.
+	if d.lossless {
+		if d.tmp[0] < 2 || d.tmp[0] > 16 {
+			return FormatError("bad lossless precision")
+		}
+	} else if d.tmp[0] != 8 && (d.tmp[0] != 12 || d.baseline) {
 		return UnsupportedError("precision")
 	}
+	d.precision = int(d.tmp[0])
 	d.height = int(d.tmp[1])<<8 + int(d.tmp[2])
 	d.width = int(d.tmp[3])<<8 + int(d.tmp[4])
 	if int(d.tmp[5]) != d.nComp {
@@ -345,7 +545,10 @@ func (d *decoder) processSOF(n int) error {
 	}
 
 	for i := 0; i < d.nComp; i++ {
-		d.comp[i].c = d.tmp[6+3*i]
+		if err := d.readFull(d.tmp[:3]); err != nil {
+			return err
+		}
+		d.comp[i].c = d.tmp[0]
 		// Section B.2.2 states that "the value of C_i shall be different from
 		// the values of C_1 through C_(i-1)".
 		for j := 0; j < i; j++ {
@@ -354,21 +557,17 @@ func (d *decoder) processSOF(n int) error {
 			}
 		}
 
-		d.comp[i].tq = d.tmp[8+3*i]
+		d.comp[i].tq = d.tmp[2]
 		if d.comp[i].tq > maxTq {
 			return FormatError("bad Tq value")
 		}
 
-		hv := d.tmp[7+3*i]
+		hv := d.tmp[1]
 		h, v := int(hv>>4), int(hv&0x0f)
 		if h < 1 || 4 < h || v < 1 || 4 < v {
 			return FormatError("luma/chroma subsampling ratio")
 		}
-		if h == 3 || v == 3 {
-			return errUnsupportedSubsamplingRatio
-		}
-		switch d.nComp {
-		case 1:
+		if d.nComp == 1 {
 			// If a JPEG image has only one component, section A.2 says "this data
 			// is non-interleaved by definition" and section A.2.2 says "[in this
 			// case...] the order of data units within a scan shall be left-to-right
@@ -381,65 +580,47 @@ func (d *decoder) processSOF(n int) error {
 			// the nominal (h, v) is (2, 1), a 20x5 image is encoded in three 8x8
 			// MCUs, not two 16x8 MCUs.
 			h, v = 1, 1
-
-		case 3:
-			// For YCbCr images, we only support 4:4:4, 4:4:0, 4:2:2, 4:2:0,
-			// 4:1:1 or 4:1:0 chroma subsampling ratios. This implies that the
-			// (h, v) values for the Y component are either (1, 1), (1, 2),
-			// (2, 1), (2, 2), (4, 1) or (4, 2), and the Y component's values
-			// must be a multiple of the Cb and Cr component's values. We also
-			// assume that the two chroma components have the same subsampling
-			// ratio.
-			switch i {
-			case 0: // Y.
-				// We have already verified, above, that h and v are both
-				// either 1, 2 or 4, so invalid (h, v) combinations are those
-				// with v == 4.
-				if v == 4 {
-					return errUnsupportedSubsamplingRatio
-				}
-			case 1: // Cb.
-				if d.comp[0].h%h != 0 || d.comp[0].v%v != 0 {
-					return errUnsupportedSubsamplingRatio
-				}
-			case 2: // Cr.
-				if d.comp[1].h != h || d.comp[1].v != v {
-					return errUnsupportedSubsamplingRatio
-				}
-			}
-
-		case 4:
-			// For 4-component images (either CMYK or YCbCrK), we only support two
-			// hv vectors: [0x11 0x11 0x11 0x11] and [0x22 0x11 0x11 0x22].
-			// Theoretically, 4-component JPEG images could mix and match hv values
-			// but in practice, those two combinations are the only ones in use,
-			// and it simplifies the applyBlack code below if we can assume that:
-			//	- for CMYK, the C and K channels have full samples, and if the M
-			//	  and Y channels subsample, they subsample both horizontally and
-			//	  vertically.
-			//	- for YCbCrK, the Y and K channels have full samples.
-			switch i {
-			case 0:
-				if hv != 0x11 && hv != 0x22 {
-					return errUnsupportedSubsamplingRatio
-				}
-			case 1, 2:
-				if hv != 0x11 {
-					return errUnsupportedSubsamplingRatio
-				}
-			case 3:
-				if d.comp[0].h != h || d.comp[0].v != v {
-					return errUnsupportedSubsamplingRatio
-				}
-			}
 		}
-
 		d.comp[i].h = h
 		d.comp[i].v = v
 	}
+	d.hMax, d.vMax = 1, 1
+	for _, c := range d.comp {
+		d.hMax, d.vMax = max(d.hMax, c.h), max(d.vMax, c.v)
+	}
+	d.upsample = !d.directLayout()
 	return nil
 }
 
+// directLayout returns whether the components' sampling factors let them be
+// decoded directly into an image.YCbCr, and a black plane at the luma
+// resolution. This is the case for 4:4:4, 4:4:0, 4:2:2, 4:2:0, 4:1:1 and
+// 4:1:0 YCbCr images, and for 4-component images whose hv vectors are
+// [0x11 0x11 0x11 0x11] or [0x22 0x11 0x11 0x22], which are the only ones
+// in use in practice. The applyBlack code below assumes that:
+//   - for CMYK, the C and K channels have full samples, and if the M
+//     and Y channels subsample, they subsample both horizontally and
+//     vertically.
+//   - for YCbCrK, the Y and K channels have full samples.
+func (d *decoder) directLayout() bool {
+	c := d.comp
+	switch d.nComp {
+	case 1:
+		return true
+	case 3:
+		if c[1].h != c[2].h || c[1].v != c[2].v || c[0].h%c[1].h != 0 || c[0].v%c[1].v != 0 {
+			return false
+		}
+		_, ok := subsampleRatio(c[0].h/c[1].h, c[0].v/c[1].v)
+		return ok
+	case 4:
+		return (c[0].h == 1 && c[0].v == 1 || c[0].h == 2 && c[0].v == 2) &&
+			c[1].h == 1 && c[1].v == 1 && c[2].h == 1 && c[2].v == 1 &&
+			c[3].h == c[0].h && c[3].v == c[0].v
+	}
+	return false
+}
+
 // Specified in section B.2.4.1.
 func (d *decoder) processDQT(n int) error {
 loop:
@@ -507,8 +688,33 @@ func (d *decoder) processApp0Marker(n int) error {
 	}
 	n -= 5
 
+	if string(d.tmp[:5]) == "JFXX\x00" {
+		return d.processJFXX(n)
+	}
 	d.jfif = d.tmp[0] == 'J' && d.tmp[1] == 'F' && d.tmp[2] == 'I' && d.tmp[3] == 'F' && d.tmp[4] == '\x00'
 
+	// The JFIF identifier is followed by the version (2 bytes), the density
+	// unit, the horizontal and vertical densities (2 bytes each), and the
+	// width and height of the thumbnail that ends the segment.
+	if d.jfif && n >= 9 {
+		if err := d.readFull(d.tmp[:9]); err != nil {
+			return err
+		}
+		n -= 9
+		d.jfifVersion = uint16(d.tmp[0])<<8 | uint16(d.tmp[1])
+		d.densityUnit = DensityUnit(d.tmp[2])
+		d.xDensity = int(d.tmp[3])<<8 + int(d.tmp[4])
+		d.yDensity = int(d.tmp[5])<<8 + int(d.tmp[6])
+		if w, h := int(d.tmp[7]), int(d.tmp[8]); w*h > 0 && 3*w*h <= n {
+			m, err := d.readRGBThumbnail(w, h)
+			if err != nil {
+				return err
+			}
+			n -= 3 * w * h
+			d.jfifThumb = m
+		}
+	}
+
 	if n > 0 {
 		return d.ignore(n)
 	}
@@ -546,8 +752,14 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 	if d.tmp[0] != 0xff || d.tmp[1] != soiMarker {
 		return nil, FormatError("missing SOI marker")
 	}
+	// Without DAC segments, the conditioning values are L = 0 and U = 1 for
+	// DC tables, and Kx = 5 for AC tables.
+	for i := range d.dac[dcTable] {
+		d.dac[dcTable][i], d.dac[acTable][i] = 0x10, 5
+	}
 
 	// Process the remaining segments until the End Of Image marker.
+loop:
 	for {
 		err := d.readFull(d.tmp[:2])
 		if err != nil {
@@ -619,14 +831,27 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 			return nil, FormatError("short segment length")
 		}
 
+		if d.appOnly && marker != app0Marker && marker != app1Marker {
+			switch {
+			case app0Marker <= marker && marker <= app15Marker, marker == comMarker,
+				marker == dqtMarker, marker == dhtMarker, marker == dacMarker, marker == driMarker:
+				if err := d.ignore(n); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, nil
+		}
+
 		switch marker {
-		case sof0Marker, sof1Marker, sof2Marker:
+		case sof0Marker, sof1Marker, sof2Marker, sof3Marker, sof9Marker, sofAMarker:
 			d.baseline = marker == sof0Marker
-			d.progressive = marker == sof2Marker
+			d.progressive = marker == sof2Marker || marker == sofAMarker
+			d.lossless = marker == sof3Marker
+			d.arithmetic = marker == sof9Marker || marker == sofAMarker
 			err = d.processSOF(n)
-			if configOnly && d.jfif {
-				return nil, err
-			}
+		case dacMarker:
+			err = d.processDAC(n)
 		case dhtMarker:
 			if configOnly {
 				err = d.ignore(n)
@@ -634,24 +859,48 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 				err = d.processDHT(n)
 			}
 		case dqtMarker:
-			if configOnly {
-				err = d.ignore(n)
-			} else {
-				err = d.processDQT(n)
-			}
+			err = d.processDQT(n)
 		case sosMarker:
-			if configOnly {
+			if configOnly && n > 0 {
+				// Read Ns, the number of components of the scan.
+				if err := d.readFull(d.tmp[:1]); err != nil {
+					return nil, err
+				}
+				d.firstScanComps = int(d.tmp[0])
+			}
+			if configOnly || d.headerOnly {
 				return nil, nil
 			}
 			err = d.processSOS(n)
-		case driMarker:
-			if configOnly {
-				err = d.ignore(n)
-			} else {
-				err = d.processDRI(n)
+			if err != nil {
+				break
+			}
+			d.scans++
+			if !d.progressive {
+				break
+			}
+			if d.onScan != nil {
+				img, err := d.image()
+				if err != nil {
+					return nil, err
+				}
+				if !d.onScan(img, d.scans-1) {
+					return img, nil
+				}
+			}
+			if d.progressiveComplete() {
+				// Later scans cannot change the output, so there is no need
+				// to read them.
+				break loop
 			}
+		case driMarker:
+			err = d.processDRI(n)
 		case app0Marker:
 			err = d.processApp0Marker(n)
+		case app1Marker:
+			err = d.processApp1Marker(n)
+		case app2Marker:
+			err = d.processApp2Marker(n)
 		case app14Marker:
 			err = d.processApp14Marker(n)
 		default:
@@ -664,32 +913,81 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 			}
 		}
 		if err != nil {
-			if d.tolerant && errors.Is(err, errShortHuffmanData) {
+			if !d.tolerant || !errors.Is(err, errShortHuffmanData) && !errors.Is(err, errShortArithData) {
+				return nil, err
+			}
+			if d.tileRuns == nil {
 				continue
 			}
-			return nil, err
+		}
+		if marker == sosMarker && d.tileRuns != nil {
+			// A tile is decoded from its restart segments only, so there is
+			// nothing left to read.
+			break
 		}
 	}
 
+	return d.image()
+}
+
+// image returns the decoded image, reconstructing it from the coefficients
+// decoded so far if the JPEG image is progressive.
+func (d *decoder) image() (image.Image, error) {
 	if d.progressive {
 		if err := d.reconstructProgressiveImage(); err != nil {
 			return nil, err
 		}
 	}
+	if d.lossless && d.samples[0] != nil {
+		if err := d.reconstructLosslessImage(); err != nil {
+			return nil, err
+		}
+	}
+	if d.imgPlanar != nil {
+		return d.cropImage(d.imgPlanar), nil
+	}
+	if d.upsample && d.planes[0] != nil {
+		d.upsamplePlanes()
+	}
 	if d.img1 != nil {
-		return d.img1, nil
+		return d.cropImage(d.img1), nil
+	}
+	if d.img16[0] != nil {
+		if d.nComp == 1 {
+			return d.cropImage(d.img16[0]), nil
+		}
+		return d.cropImage(d.convertToRGBA64()), nil
 	}
 	if d.img3 != nil {
+		var img image.Image = d.img3
+		var err error
 		if d.blackPix != nil {
-			return d.applyBlack()
+			img, err = d.applyBlack()
 		} else if d.isRGB() {
-			return d.convertToRGB()
+			img, err = d.convertToRGB()
+		}
+		if err != nil {
+			return nil, err
 		}
-		return d.img3, nil
+		return d.cropImage(img), nil
 	}
 	return nil, FormatError("missing SOS marker")
 }
 
+// cropImage returns the part of img inside the scaled crop rectangle. img
+// covers the MCUs intersecting the crop rectangle, which may be larger.
+func (d *decoder) cropImage(img image.Image) image.Image {
+	if img.Bounds() == d.cropScaled {
+		return img
+	}
+	return img.(subImager).SubImage(d.cropScaled)
+}
+
+// subImager is implemented by the image types that the decoder returns.
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
 // applyBlack combines d.img3 and d.blackPix into a CMYK image. The formula
 // used depends on whether the JPEG image is stored as CMYK or YCbCrK,
 // indicated by the APP14 (Adobe) metadata.
@@ -719,11 +1017,11 @@ func (d *decoder) applyBlack() (image.Image, error) {
 				img.Pix[i] = 255 - d.blackPix[(y-bounds.Min.Y)*d.blackStride+(x-bounds.Min.X)]
 			}
 		}
-		return &image.CMYK{
+		return d.cmykImage(&image.CMYK{
 			Pix:    img.Pix,
 			Stride: img.Stride,
 			Rect:   img.Rect,
-		}, nil
+		}), nil
 	}
 
 	// The first three channels (cyan, magenta, yellow) of the CMYK
@@ -744,7 +1042,7 @@ func (d *decoder) applyBlack() (image.Image, error) {
 		{d.blackPix, d.blackStride},
 	}
 	for t, translation := range translations {
-		subsample := d.comp[t].h != d.comp[0].h || d.comp[t].v != d.comp[0].v
+		subsample := !d.fullChroma && !d.upsample && (d.comp[t].h != d.comp[0].h || d.comp[t].v != d.comp[0].v)
 		for iBase, y := 0, bounds.Min.Y; y < bounds.Max.Y; iBase, y = iBase+img.Stride, y+1 {
 			sy := y - bounds.Min.Y
 			if subsample {
@@ -759,7 +1057,16 @@ func (d *decoder) applyBlack() (image.Image, error) {
 			}
 		}
 	}
-	return img, nil
+	return d.cmykImage(img), nil
+}
+
+// cmykImage returns img, or img converted to RGB in place if d.cmykToRGB is
+// set.
+func (d *decoder) cmykImage(img *image.CMYK) image.Image {
+	if d.cmykToRGB {
+		return cmykToRGBA(img, d.iccProfile())
+	}
+	return img
 }
 
 func (d *decoder) isRGB() bool {
@@ -776,6 +1083,9 @@ func (d *decoder) isRGB() bool {
 
 func (d *decoder) convertToRGB() (image.Image, error) {
 	cScale := d.comp[0].h / d.comp[1].h
+	if d.fullChroma || d.upsample {
+		cScale = 1
+	}
 	bounds := d.img3.Bounds()
 	img := image.NewRGBA(bounds)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
@@ -792,21 +1102,163 @@ func (d *decoder) convertToRGB() (image.Image, error) {
 	return img, nil
 }
 
+// convertToRGBA64 converts the 3 components of a 12-bit image in d.img16 to
+// an RGBA64 image, from YCbCr as color.YCbCrToRGB does, or from RGB.
+func (d *decoder) convertToRGBA64() *image.RGBA64 {
+	hScale, vScale := d.comp[0].h/d.comp[1].h, d.comp[0].v/d.comp[1].v
+	if d.fullChroma || d.upsample {
+		hScale, vScale = 1, 1
+	}
+	rgb := d.isRGB()
+	y16, cb16, cr16 := d.img16[0], d.img16[1], d.img16[2]
+	bounds := y16.Bounds()
+	img := image.NewRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		po := img.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x, po = x+1, po+8 {
+			yy := int32(y16.Gray16At(x, y).Y)
+			cb := int32(cb16.Gray16At(x/hScale, y/vScale).Y)
+			cr := int32(cr16.Gray16At(x/hScale, y/vScale).Y)
+			r, g, b := yy, cb, cr
+			if !rgb {
+				// The coefficients are those of color.YCbCrToRGB, scaled by
+				// 1<<16 and applied to 16-bit samples.
+				cb -= 0x8000
+				cr -= 0x8000
+				r = yy + int32((91881*int64(cr)+1<<15)>>16)
+				g = yy - int32((22554*int64(cb)+46802*int64(cr)+1<<15)>>16)
+				b = yy + int32((116130*int64(cb)+1<<15)>>16)
+			}
+			for i, v := range [4]int32{r, g, b, 0xffff} {
+				v = min(max(v, 0), 0xffff)
+				img.Pix[po+2*i] = uint8(v >> 8)
+				img.Pix[po+2*i+1] = uint8(v)
+			}
+		}
+	}
+	return img
+}
+
 // DecodeOptions specifies JPEG decoding parameters.
 type DecodeOptions struct {
-	// DCTSizeScaled allowed from 8 to 1. 8 is 100% size, 4 is 50%, 1 is 1/8 of original size.
+	// DCTSizeScaled allowed from 16 to 1. 8 is 100% size, 4 is 50%, 1 is 1/8 of original size.
+	// Values above 8 enlarge the image directly from the DCT domain, up to 200% for 16.
+	// Lossless images are reduced by averaging the samples, and enlarged by repeating them.
 	DCTSizeScaled int
+	// DCTSizeScaledX and DCTSizeScaledY, if non-zero, override DCTSizeScaled
+	// for the horizontal and vertical axis respectively, e.g. 8 and 4 halve
+	// the height only. They allow correcting non-square pixels or decoding
+	// to a different aspect ratio without a second resize pass.
+	DCTSizeScaledX, DCTSizeScaledY int
 	// Tolerant enables lenient decoding of truncated or malformed images.
 	Tolerant bool
+	// FitTo, if non-zero, is a bounding box that the image should fit into,
+	// preserving its aspect ratio. The smallest DCTSizeScaled whose output
+	// still covers the fitted size is picked from the frame header, and
+	// DCTSizeScaled, DCTSizeScaledX and DCTSizeScaledY are ignored. A zero
+	// X or Y leaves that dimension unconstrained.
+	FitTo image.Point
+	// FullChroma decodes subsampled chroma components with a proportionally
+	// larger IDCT (e.g. 16x16 instead of 8x8 for 4:2:0), so that the result
+	// is a 4:4:4 image with sharper colour edges than nearest-neighbour
+	// chroma replication gives. It is ignored when that IDCT would be larger
+	// than 16x16, e.g. for 4:2:0 with DCTSizeScaled above 8.
+	FullChroma bool
+	// Crop, if non-empty, is the region of the image to decode, in source
+	// (unscaled) pixel coordinates. Every MCU is still entropy-decoded, but
+	// only those intersecting Crop are transformed and allocated. The
+	// returned image's bounds are Crop scaled by the DCT size, and so may
+	// have a non-zero Min. FitTo applies to the size of Crop.
+	Crop image.Rectangle
+	// Concurrency, if greater than 1, is the maximum number of goroutines
+	// that decode the restart intervals of a sequential scan, or
+	// reconstruct the blocks of a progressive image, in parallel. The scans
+	// of progressive images, and sequential images without restart markers,
+	// are decoded on a single goroutine.
+	Concurrency int
+	// StopEarly stops reading a progressive image as soon as the scans read
+	// so far have completed every coefficient that the scaled IDCT uses,
+	// leaving the rest of the reader unread. This is always done when both
+	// DCT sizes are 1, as only the DC coefficients matter then.
+	StopEarly bool
+	// OnScan, if non-nil, is called for progressive images after every
+	// scan, with the image reconstructed from the coefficients decoded so
+	// far and the zero-based index of the scan. The image's pixels may be
+	// reused by later scans, so it must be copied to keep it past the call.
+	// If OnScan returns false, decoding stops and Decode returns that image.
+	OnScan func(img image.Image, scanIndex int) bool
+	// Resample resizes the image decoded for FitTo to exactly the fitted size
+	// with a Catmull-Rom filter. It has no effect if FitTo is zero.
+	Resample bool
+	// AutoOrient rotates and flips the decoded image as the EXIF orientation
+	// tag says it should be displayed. The bounds of an image that is
+	// transformed start at 0, 0. FitTo is the box of the oriented image,
+	// while Crop stays in the coordinates of the image as stored. OnScan
+	// images are not oriented.
+	AutoOrient bool
+	// ConvertToSRGB converts RGB and YCbCr images whose embedded ICC profile
+	// is a matrix/TRC RGB profile other than sRGB, such as Display P3, Adobe
+	// RGB or ProPhoto RGB, to sRGB. Such images are returned as
+	// *image.RGBA, with out-of-gamut colours clipped. Other images,
+	// including 12-bit ones and those with other kinds of profiles, are left
+	// unchanged.
+	ConvertToSRGB bool
+	// CMYKToRGB converts CMYK and YCCK images to *image.RGBA while decoding,
+	// in place of the *image.CMYK that Decode returns otherwise. The A2B0
+	// transform of an embedded CMYK ICC profile converts them to sRGB when
+	// it is supported, and the naive formula of color.CMYKToRGB is used
+	// otherwise.
+	CMYKToRGB bool
 }
 
 // Decode reads a JPEG image from r and returns it as an [image.Image].
 func Decode(r io.Reader, opts DecodeOptions) (image.Image, error) {
-	d := decoder{
-		dctSizeScaled: opts.DCTSizeScaled,
-		tolerant:      opts.Tolerant,
+	d := newDecoder(opts)
+	return d.decodeImage(r, opts)
+}
+
+// newDecoder returns a decoder configured by opts.
+func newDecoder(opts DecodeOptions) *decoder {
+	d := &decoder{
+		dctSizeScaledX: opts.DCTSizeScaled,
+		dctSizeScaledY: opts.DCTSizeScaled,
+		fitTo:          opts.FitTo,
+		fullChroma:     opts.FullChroma,
+		crop:           opts.Crop,
+		stopEarly:      opts.StopEarly,
+		onScan:         opts.OnScan,
+		concurrency:    opts.Concurrency,
+		tolerant:       opts.Tolerant,
+		autoOrient:     opts.AutoOrient,
+		cmykToRGB:      opts.CMYKToRGB,
+	}
+	if opts.DCTSizeScaledX != 0 {
+		d.dctSizeScaledX = opts.DCTSizeScaledX
+	}
+	if opts.DCTSizeScaledY != 0 {
+		d.dctSizeScaledY = opts.DCTSizeScaledY
 	}
-	return d.decode(r, false)
+	return d
+}
+
+// decodeImage decodes the image read from r, and applies the processing
+// that opts asks for after decoding.
+func (d *decoder) decodeImage(r io.Reader, opts DecodeOptions) (image.Image, error) {
+	img, err := d.decode(r, false)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Resample && d.fitTo != (image.Point{}) {
+		w, h := fitSize(d.crop.Dx(), d.crop.Dy(), d.fitTo)
+		img = resize(img, w, h)
+	}
+	if opts.ConvertToSRGB {
+		img = convertToSRGB(img, d.iccProfile())
+	}
+	if d.autoOrient {
+		img = orient(img, d.orientation)
+	}
+	return img, nil
 }
 
 // DecodeConfig returns jpeg type (Baseline, Progressive), the color model and dimensions of a JPEG image without
@@ -816,46 +1268,104 @@ func DecodeConfig(r io.Reader) (Config, error) {
 	if _, err := d.decode(r, true); err != nil {
 		return Config{}, err
 	}
+	return d.config()
+}
 
+// Result is a decoded image together with the metadata read from its
+// segments.
+type Result struct {
+	Image image.Image
+	// Config describes the image as stored, before scaling, cropping and
+	// orienting, as DecodeConfig would return it.
+	Config Config
+}
+
+// DecodeResult reads a JPEG image from r and decodes it as Decode does,
+// returning its metadata too.
+func DecodeResult(r io.Reader, opts DecodeOptions) (*Result, error) {
+	d := newDecoder(opts)
+	img, err := d.decodeImage(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := d.config()
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Image: img, Config: cfg}, nil
+}
+
+// config returns the Config of the image whose segments d has read.
+func (d *decoder) config() (Config, error) {
 	jpegType := JpegTypeUnsupported
 	if d.baseline {
 		jpegType = JpegTypeBaseline
 	} else if d.progressive {
 		jpegType = JpegTypeProgressive
+	} else if d.lossless {
+		jpegType = JpegTypeLossless
+	} else {
+		// SOF1 and SOF9, Huffman and arithmetic coded.
+		jpegType = JpegTypeExtended
 	}
 
+	var cm color.Model
 	switch d.nComp {
 	case 1:
-		return Config{
-			Config: image.Config{
-				ColorModel: color.GrayModel,
-				Width:      d.width,
-				Height:     d.height,
-			},
-			JpegType: jpegType,
-		}, nil
+		cm = color.GrayModel
+		if d.precision > 8 {
+			cm = color.Gray16Model
+		}
 	case 3:
-		cm := color.YCbCrModel
-		if d.isRGB() {
+		cm = color.YCbCrModel
+		if d.precision > 8 {
+			cm = color.RGBA64Model
+		} else if d.isRGB() {
 			cm = color.RGBAModel
 		}
-		return Config{
-			Config: image.Config{
-				ColorModel: cm,
-				Width:      d.width,
-				Height:     d.height,
-			},
-			JpegType: jpegType,
-		}, nil
 	case 4:
-		return Config{
-			Config: image.Config{
-				ColorModel: color.CMYKModel,
-				Width:      d.width,
-				Height:     d.height,
-			},
-			JpegType: jpegType,
-		}, nil
-	}
-	return Config{}, FormatError("missing SOF marker")
+		cm = color.CMYKModel
+	case 0:
+		return Config{}, FormatError("missing SOF marker")
+	default:
+		// Planar images report their first component as gray.
+		cm = color.GrayModel
+	}
+	cfg := Config{
+		Config: image.Config{
+			ColorModel: cm,
+			Width:      d.width,
+			Height:     d.height,
+		},
+		JpegType:        jpegType,
+		Arithmetic:      d.arithmetic,
+		Orientation:     d.orientation,
+		ICCProfile:      d.iccProfile(),
+		Components:      make([]ComponentInfo, d.nComp),
+		Adobe:           d.adobeTransformValid,
+		AdobeTransform:  d.adobeTransform,
+		JFIF:            d.jfif,
+		RestartInterval: d.ri,
+		Precision:       d.precision,
+		Scans:           d.scans,
+	}
+	if d.jfif {
+		cfg.JFIFVersion = d.jfifVersion
+	}
+	cfg.JFIFThumbnail, cfg.JFIFThumbnailJPEG = d.jfifThumb, d.jfxxJPEG
+	cfg.Quality, cfg.StandardQuant = d.quality()
+	for i := range cfg.Components {
+		cfg.Components[i] = ComponentInfo{ID: d.comp[i].c, H: d.comp[i].h, V: d.comp[i].v}
+	}
+	if d.nComp == 3 && !d.upsample {
+		cfg.SubsampleRatio, _ = subsampleRatio(d.comp[0].h/d.comp[1].h, d.comp[0].v/d.comp[1].v)
+	}
+	if d.jfif {
+		cfg.DensityUnit, cfg.XDensity, cfg.YDensity = d.densityUnit, d.xDensity, d.yDensity
+	}
+	if cfg.Scans == 0 && !d.progressive && d.firstScanComps == d.nComp {
+		// In sequential mode, every component is in exactly one scan.
+		cfg.Scans = 1
+	}
+	return cfg, nil
 }
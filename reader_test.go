@@ -64,6 +64,377 @@ func TestDecodeProgressive(t *testing.T) {
 	}
 }
 
+// TestDecodeUpscaled tests that DCT sizes above 8 enlarge the image, and
+// that each 2x2 pixel square of the 16x16 IDCT output averages to roughly
+// the corresponding pixel of the full size image. The match is not exact:
+// averaging sample pairs of a 16-point IDCT attenuates coefficient k by
+// cos(k*pi/32), so detailed images differ by a few levels.
+func TestDecodeUpscaled(t *testing.T) {
+	for dctScaledSize := DCTSIZE + 1; dctScaledSize <= maxDCTSize; dctScaledSize++ {
+		t.Run(fmt.Sprintf("dct size %d", dctScaledSize), func(t *testing.T) {
+			want := image.Rect(0, 0, 150*dctScaledSize/DCTSIZE, 103*dctScaledSize/DCTSIZE)
+			for _, it := range imageTests {
+				m, err := decodeFile(it.filename, dctScaledSize)
+				if err != nil {
+					t.Errorf("%s: %v", it.filename, err)
+					continue
+				}
+				if m.Bounds() != want {
+					t.Errorf("%s: got bounds %v, want %v", it.filename, m.Bounds(), want)
+					continue
+				}
+				if dctScaledSize != maxDCTSize {
+					continue
+				}
+				m0, err := decodeFile(it.filename, DCTSIZE)
+				if err != nil {
+					t.Fatal(err)
+				}
+				small := image.NewRGBA(m0.Bounds())
+				for y := 0; y < small.Rect.Dy(); y++ {
+					for x := 0; x < small.Rect.Dx(); x++ {
+						var sum [4]uint32
+						for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+							r, g, b, a := m.At(2*x+p.X, 2*y+p.Y).RGBA()
+							sum[0], sum[1], sum[2], sum[3] = sum[0]+r, sum[1]+g, sum[2]+b, sum[3]+a
+						}
+						small.Set(x, y, color.RGBA64{uint16(sum[0] / 4), uint16(sum[1] / 4), uint16(sum[2] / 4), uint16(sum[3] / 4)})
+					}
+				}
+				if d := averageDelta(small, m0); d > 6<<8 {
+					t.Errorf("%s: average delta too high: %d", it.filename, d)
+				}
+			}
+		})
+	}
+}
+
+// TestDecodeUpscaledLibjpeg tests that DCT sizes above 8 give the samples
+// of libjpeg's jpeg_idct_NxN routines. The golden images are libjpeg's
+// output at a scale of N/8, which rounds the image size up.
+func TestDecodeUpscaledLibjpeg(t *testing.T) {
+	for dctScaledSize := DCTSIZE + 1; dctScaledSize <= maxDCTSize; dctScaledSize++ {
+		m, err := decodeFile("testdata/video-005.gray.q50.jpeg", dctScaledSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := decodeStd(fmt.Sprintf("testdata/video-005.gray.q50#%d.png", dctScaledSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := m.Bounds()
+		if !b.In(g.Bounds()) {
+			t.Errorf("dct size %d: got bounds %v, golden bounds %v", dctScaledSize, b, g.Bounds())
+			continue
+		}
+	loop:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if got, want := m.At(x, y), g.At(x, y); got != want {
+					t.Errorf("dct size %d: at (%d, %d): got %v, want %v", dctScaledSize, x, y, got, want)
+					break loop
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeNonSquare tests that independent horizontal and vertical DCT
+// sizes scale each axis separately, and that halving one axis gives roughly
+// the full size image with pairs of pixels averaged along that axis.
+func TestDecodeNonSquare(t *testing.T) {
+	for _, tc := range []struct{ x, y int }{{8, 4}, {4, 8}, {16, 8}, {2, 6}, {3, 16}} {
+		want := image.Rect(0, 0, 150*tc.x/DCTSIZE, 103*tc.y/DCTSIZE)
+		for _, it := range imageTests {
+			m, err := decodeFileWithOptions(it.filename, DecodeOptions{DCTSizeScaledX: tc.x, DCTSizeScaledY: tc.y})
+			if err != nil {
+				t.Errorf("%s %dx%d: %v", it.filename, tc.x, tc.y, err)
+				continue
+			}
+			if m.Bounds() != want {
+				t.Errorf("%s %dx%d: got bounds %v, want %v", it.filename, tc.x, tc.y, m.Bounds(), want)
+				continue
+			}
+			if tc.x != 2*tc.y && tc.y != 2*tc.x {
+				continue
+			}
+			m0, err := decodeFile(it.filename, min(tc.x, tc.y))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dx, dy := tc.x/min(tc.x, tc.y), tc.y/min(tc.x, tc.y)
+			small := image.NewRGBA(m0.Bounds())
+			for y := 0; y < small.Rect.Dy(); y++ {
+				for x := 0; x < small.Rect.Dx(); x++ {
+					r0, g0, b0, a0 := m.At(dx*x, dy*y).RGBA()
+					r1, g1, b1, a1 := m.At(dx*x+dx-1, dy*y+dy-1).RGBA()
+					small.Set(x, y, color.RGBA64{uint16((r0 + r1) / 2), uint16((g0 + g1) / 2), uint16((b0 + b1) / 2), uint16((a0 + a1) / 2)})
+				}
+			}
+			if d := averageDelta(small, m0); d > 7<<8 {
+				t.Errorf("%s %dx%d: average delta too high: %d", it.filename, tc.x, tc.y, d)
+			}
+		}
+	}
+}
+
+// TestDecodeFullChroma tests that FullChroma decodes subsampled images to
+// 4:4:4 when the chroma IDCT size allows it, and that the result stays close
+// to the default chroma replication.
+func TestDecodeFullChroma(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		size     int
+		want     image.YCbCrSubsampleRatio
+	}{
+		{"testdata/video-001.q50.420.jpeg", 8, image.YCbCrSubsampleRatio444},
+		{"testdata/video-001.q50.420.progressive.jpeg", 4, image.YCbCrSubsampleRatio444},
+		{"testdata/video-001.q50.422.jpeg", 3, image.YCbCrSubsampleRatio444},
+		{"testdata/video-001.q50.440.jpeg", 8, image.YCbCrSubsampleRatio444},
+		{"testdata/video-001.q50.411.jpeg", 4, image.YCbCrSubsampleRatio444},
+		{"testdata/video-001.q50.410.jpeg", 4, image.YCbCrSubsampleRatio444},
+		// The 32-wide chroma IDCT is not supported, so the ratio is kept.
+		{"testdata/video-001.q50.411.jpeg", 8, image.YCbCrSubsampleRatio411},
+		{"testdata/video-001.q50.420.jpeg", 12, image.YCbCrSubsampleRatio420},
+	} {
+		m0, err := decodeFile(tc.filename, tc.size)
+		if err != nil {
+			t.Errorf("%s: %v", tc.filename, err)
+			continue
+		}
+		m, err := decodeFileWithOptions(tc.filename, DecodeOptions{DCTSizeScaled: tc.size, FullChroma: true})
+		if err != nil {
+			t.Errorf("%s: %v", tc.filename, err)
+			continue
+		}
+		ycc, ok := m.(*image.YCbCr)
+		if !ok || ycc.SubsampleRatio != tc.want {
+			t.Errorf("%s #%d: got %T, want %v", tc.filename, tc.size, m, tc.want)
+			continue
+		}
+		if m.Bounds() != m0.Bounds() {
+			t.Errorf("%s #%d: got bounds %v, want %v", tc.filename, tc.size, m.Bounds(), m0.Bounds())
+			continue
+		}
+		if d := averageDelta(m, m0); d > 3<<8 {
+			t.Errorf("%s #%d: average delta too high: %d", tc.filename, tc.size, d)
+		}
+	}
+	m, err := decodeFileWithOptions("testdata/video-001.cmyk.jpeg", DecodeOptions{FullChroma: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m0, err := decodeFile("testdata/video-001.cmyk.jpeg", DCTSIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := averageDelta(m, m0); d > 3<<8 {
+		t.Errorf("cmyk: average delta too high: %d", d)
+	}
+}
+
+// TestDecodeCrop tests that decoding a crop rectangle gives the same pixels
+// as cropping the fully decoded image.
+func TestDecodeCrop(t *testing.T) {
+	crops := []image.Rectangle{
+		image.Rect(0, 0, 150, 103),
+		image.Rect(17, 9, 61, 50),
+		image.Rect(32, 16, 64, 48),
+		image.Rect(100, 90, 200, 200),
+		image.Rect(149, 102, 150, 103),
+	}
+	for _, it := range imageTests {
+		for _, size := range []int{8, 5, 1, 16} {
+			m0, err := decodeFile(it.filename, size)
+			if err != nil {
+				t.Errorf("%s: %v", it.filename, err)
+				continue
+			}
+			for _, crop := range crops {
+				m, err := decodeFileWithOptions(it.filename, DecodeOptions{DCTSizeScaled: size, Crop: crop})
+				if err != nil {
+					t.Errorf("%s #%d %v: %v", it.filename, size, crop, err)
+					continue
+				}
+				b := m.Bounds()
+				if !b.In(m0.Bounds()) || b.Empty() {
+					t.Errorf("%s #%d %v: got bounds %v", it.filename, size, crop, b)
+					continue
+				}
+				if size == DCTSIZE && b != crop.Intersect(m0.Bounds()) {
+					t.Errorf("%s #%d %v: got bounds %v", it.filename, size, crop, b)
+					continue
+				}
+				if err := equalImages(m, m0.(subImager).SubImage(b)); err != nil {
+					t.Errorf("%s #%d %v: %v", it.filename, size, crop, err)
+				}
+			}
+		}
+	}
+	if _, err := decodeFileWithOptions("testdata/video-001.jpeg", DecodeOptions{Crop: image.Rect(200, 200, 300, 300)}); err == nil {
+		t.Error("crop outside of the image: got nil error")
+	}
+}
+
+// TestDecodeStopEarly tests that progressive decoding stops once the scans
+// that affect the output have been read, by cutting the image off right
+// after them.
+func TestDecodeStopEarly(t *testing.T) {
+	for _, filename := range []string{
+		"testdata/video-001.progressive.jpeg",
+		"testdata/video-001.q50.420.progressive.jpeg",
+		"testdata/video-005.gray.q50.progressive.jpeg",
+	} {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Cut the data at the first scan that follows the last DC scan.
+		scans := scanOffsets(data)
+		last := -1
+		for i, sc := range scans {
+			if sc.ss == 0 {
+				last = i
+			}
+		}
+		if last < 0 || last+1 >= len(scans) {
+			t.Fatalf("%s: no AC scans after the DC scans", filename)
+		}
+		cut := data[:scans[last+1].offset]
+
+		want, err := decodeFile(filename, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(bytes.NewReader(cut), DecodeOptions{DCTSizeScaled: 1})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if err := equalImages(m, want); err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+		if _, err := Decode(bytes.NewReader(cut), DecodeOptions{DCTSizeScaled: 2}); err == nil {
+			t.Errorf("%s: scale 2: got nil error", filename)
+		}
+	}
+
+	// With StopEarly, larger scales stop once the coefficients that they use
+	// are complete. The AC scans of this image are split at coefficient 5,
+	// and scale 2 needs none of the scans of coefficients 6 to 63 that follow.
+	const filename = "testdata/video-001.q50.420.spectral.progressive.jpeg"
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cut []byte
+	for _, sc := range scanOffsets(data) {
+		if sc.ss == 6 {
+			cut = data[:sc.offset]
+			break
+		}
+	}
+	if cut == nil {
+		t.Fatalf("%s: no scan of coefficients 6 to 63", filename)
+	}
+	want, err := decodeFile(filename, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(bytes.NewReader(cut), DecodeOptions{DCTSizeScaled: 2, StopEarly: true})
+	if err != nil {
+		t.Fatalf("%s: StopEarly: %v", filename, err)
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Errorf("%s: StopEarly: %v", filename, err)
+	}
+	if _, err := Decode(bytes.NewReader(cut), DecodeOptions{DCTSizeScaled: 2}); err == nil {
+		t.Errorf("%s: scale 2: got nil error", filename)
+	}
+}
+
+// TestDecodeOnScan tests that OnScan is called after every progressive scan,
+// that the last call gets the final image, and that returning false stops
+// decoding.
+func TestDecodeOnScan(t *testing.T) {
+	const filename = "testdata/video-001.progressive.jpeg"
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nScans := len(scanOffsets(data))
+	want, err := decodeFile(filename, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var indexes []int
+	var last image.Image
+	m, err := Decode(bytes.NewReader(data), DecodeOptions{DCTSizeScaled: 4, OnScan: func(img image.Image, scanIndex int) bool {
+		indexes = append(indexes, scanIndex)
+		last = toRGBA(img)
+		return true
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != nScans {
+		t.Fatalf("got %d calls, want %d", len(indexes), nScans)
+	}
+	for i, index := range indexes {
+		if index != i {
+			t.Fatalf("call %d: got scan index %d", i, index)
+		}
+	}
+	if err := equalImages(m, want); err != nil {
+		t.Errorf("decoded image: %v", err)
+	}
+	if err := equalImages(last, toRGBA(want)); err != nil {
+		t.Errorf("last OnScan image: %v", err)
+	}
+
+	calls := 0
+	m, err = Decode(bytes.NewReader(data), DecodeOptions{DCTSizeScaled: 4, OnScan: func(img image.Image, scanIndex int) bool {
+		calls++
+		return scanIndex < 1
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+	if m.Bounds() != want.Bounds() {
+		t.Errorf("got bounds %v, want %v", m.Bounds(), want.Bounds())
+	}
+	if equalImages(m, want) == nil {
+		t.Error("image after two scans equals the final image")
+	}
+}
+
+// scanOffsets returns the offsets of the SOS markers in data, and the
+// spectral selection start of their scans.
+func scanOffsets(data []byte) (scans []struct{ offset, ss int }) {
+	for i := 2; i+4 < len(data); {
+		if data[i] != 0xff {
+			i++
+			continue
+		}
+		marker := data[i+1]
+		if marker == 0x00 || marker == 0xff || (rst0Marker <= marker && marker <= rst7Marker) {
+			i++
+			continue
+		}
+		n := int(data[i+2])<<8 | int(data[i+3])
+		if marker == sosMarker {
+			ns := int(data[i+4])
+			scans = append(scans, struct{ offset, ss int }{i, int(data[i+5+2*ns])})
+		}
+		i += 2 + n
+	}
+	return scans
+}
+
 func testDecodeProgressive(t *testing.T, tc string, dctScaledSize int, expectedRect image.Rectangle) {
 	m0, err := decodeFile(tc+".jpeg", dctScaledSize)
 	if err != nil {
@@ -686,6 +1057,23 @@ func averageDelta(m0, m1 image.Image) int64 {
 	return sum / n
 }
 
+// equalImages returns an error describing the first difference between the
+// bounds or the pixels of m0 and m1, or nil if they are equal.
+func equalImages(m0, m1 image.Image) error {
+	b := m0.Bounds()
+	if b != m1.Bounds() {
+		return fmt.Errorf("bounds differ: %v and %v", b, m1.Bounds())
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c0, c1 := rgba(m0.At(x, y)), rgba(m1.At(x, y)); c0 != c1 {
+				return fmt.Errorf("pixel (%d, %d): got %s, want %s", x, y, c0, c1)
+			}
+		}
+	}
+	return nil
+}
+
 func delta(u0, u1 uint32) int64 {
 	d := int64(u0) - int64(u1)
 	if d < 0 {
@@ -0,0 +1,263 @@
+package jpegscaled
+
+import (
+	"image"
+	"math"
+)
+
+// fitSize returns the size that a width x height image should be resized to
+// in order to fit into box while preserving its aspect ratio. A zero (or
+// negative) box dimension leaves that dimension unconstrained.
+func fitSize(width, height int, box image.Point) (int, int) {
+	bx, by := max(box.X, 0), max(box.Y, 0)
+	if width <= 0 || height <= 0 || (bx == 0 && by == 0) {
+		return width, height
+	}
+	var fw, fh int
+	if by == 0 || (bx != 0 && bx*height <= by*width) {
+		fw = bx
+		fh = (height*bx + width/2) / width
+	} else {
+		fh = by
+		fw = (width*by + height/2) / height
+	}
+	return max(fw, 1), max(fh, 1)
+}
+
+// fitDCTSize returns the smallest DCT scale whose output for a width x height
+// image is at least as large as the size returned by fitSize. If no scale
+// covers it (the box requires more than 2x enlargement), the largest scale
+// is returned.
+func fitDCTSize(width, height int, box image.Point) int {
+	fw, fh := fitSize(width, height, box)
+	for n := 1; n < maxDCTSize; n++ {
+		if max(width*n/DCTSIZE, 1) >= fw && max(height*n/DCTSIZE, 1) >= fh {
+			return n
+		}
+	}
+	return maxDCTSize
+}
+
+// resize returns m resampled to w x h pixels. It supports the image types
+// produced by the decoder and returns m unchanged for any other type or if m
+// already has the requested size.
+func resize(m image.Image, w, h int) image.Image {
+	b := m.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return m
+	}
+	switch m := m.(type) {
+	case *image.Gray:
+		dst := image.NewGray(image.Rect(0, 0, w, h))
+		resamplePlane(dst.Pix, dst.Stride, w, h, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b.Dx(), b.Dy(), 1)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(image.Rect(0, 0, w, h))
+		resamplePlane16(dst.Pix, dst.Stride, w, h, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b.Dx(), b.Dy(), 1)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		resamplePlane(dst.Pix, dst.Stride, w, h, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b.Dx(), b.Dy(), 4)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+		resamplePlane16(dst.Pix, dst.Stride, w, h, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b.Dx(), b.Dy(), 4)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(image.Rect(0, 0, w, h))
+		resamplePlane(dst.Pix, dst.Stride, w, h, m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, b.Dx(), b.Dy(), 4)
+		return dst
+	case *image.YCbCr:
+		dst := image.NewYCbCr(image.Rect(0, 0, w, h), m.SubsampleRatio)
+		resamplePlane(dst.Y, dst.YStride, w, h, m.Y[m.YOffset(b.Min.X, b.Min.Y):], m.YStride, b.Dx(), b.Dy(), 1)
+		sc, dc := chromaRect(b, m.SubsampleRatio), chromaRect(dst.Rect, m.SubsampleRatio)
+		co := m.COffset(b.Min.X, b.Min.Y)
+		resamplePlane(dst.Cb, dst.CStride, dc.Dx(), dc.Dy(), m.Cb[co:], m.CStride, sc.Dx(), sc.Dy(), 1)
+		resamplePlane(dst.Cr, dst.CStride, dc.Dx(), dc.Dy(), m.Cr[co:], m.CStride, sc.Dx(), sc.Dy(), 1)
+		return dst
+	}
+	return m
+}
+
+// chromaRect returns the rectangle of the chroma planes of a YCbCr image with
+// bounds r and the given subsample ratio, in chroma sample coordinates.
+func chromaRect(r image.Rectangle, ratio image.YCbCrSubsampleRatio) image.Rectangle {
+	hDiv, vDiv := subsampleDivisors(ratio)
+	return image.Rect(
+		r.Min.X/hDiv, r.Min.Y/vDiv,
+		(r.Max.X+hDiv-1)/hDiv, (r.Max.Y+vDiv-1)/vDiv,
+	)
+}
+
+// subsampleDivisors returns the number of luma samples per chroma sample
+// horizontally and vertically for the given subsample ratio.
+func subsampleDivisors(ratio image.YCbCrSubsampleRatio) (hDiv, vDiv int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
+// resampleBits is the fixed-point precision of the resampling weights.
+const resampleBits = 14
+
+// resampleTaps holds, for every destination sample along one axis, the
+// source sample indexes and the fixed-point weights that contribute to it.
+type resampleTaps struct {
+	n       int     // Number of taps per destination sample.
+	index   []int   // Source indexes, n per destination sample.
+	weights []int32 // Weights summing to 1<<resampleBits, n per destination sample.
+}
+
+// catmullRom is the Catmull-Rom cubic convolution kernel, with support 2.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (9*x-15)*x*x/6 + 1
+	case x < 2:
+		return ((-3*x+15)*x-24)*x/6 + 2
+	}
+	return 0
+}
+
+// makeTaps computes the taps resampling srcLen samples to dstLen samples.
+// When downsampling, the kernel is stretched by the scale factor so that
+// every source sample contributes to the output.
+func makeTaps(dstLen, srcLen int) resampleTaps {
+	scale := float64(srcLen) / float64(dstLen)
+	filterScale := math.Max(scale, 1)
+	support := 2 * filterScale
+	t := resampleTaps{n: int(math.Ceil(support))*2 + 1}
+	t.index = make([]int, dstLen*t.n)
+	t.weights = make([]int32, dstLen*t.n)
+	fw := make([]float64, t.n)
+	for i := 0; i < dstLen; i++ {
+		center := (float64(i)+0.5)*scale - 0.5
+		left := int(math.Ceil(center - support))
+		sum := 0.0
+		for k := range fw {
+			fw[k] = catmullRom((float64(left+k) - center) / filterScale)
+			sum += fw[k]
+		}
+		// Distribute the rounding error onto the largest tap so that the
+		// weights always sum to exactly 1<<resampleBits.
+		total, largest := int32(0), 0
+		for k := range fw {
+			j := i*t.n + k
+			t.index[j] = min(max(left+k, 0), srcLen-1)
+			t.weights[j] = int32(math.Round(fw[k] / sum * (1 << resampleBits)))
+			total += t.weights[j]
+			if fw[k] > fw[largest] {
+				largest = k
+			}
+		}
+		t.weights[i*t.n+largest] += 1<<resampleBits - total
+	}
+	return t
+}
+
+// resamplePlane resamples a sw x sh plane of n interleaved channels in src to
+// a dw x dh plane in dst, using separable Catmull-Rom filtering.
+func resamplePlane(dst []byte, dstStride, dw, dh int, src []byte, srcStride, sw, sh, n int) {
+	if dw <= 0 || dh <= 0 || sw <= 0 || sh <= 0 {
+		return
+	}
+	hTaps, vTaps := makeTaps(dw, sw), makeTaps(dh, sh)
+
+	// Horizontal pass: tmp holds sh rows of dw*n fixed-point samples.
+	tmp := make([]int32, sh*dw*n)
+	for y := 0; y < sh; y++ {
+		row := src[y*srcStride:]
+		out := tmp[y*dw*n:]
+		for x := 0; x < dw; x++ {
+			taps := hTaps.index[x*hTaps.n : (x+1)*hTaps.n]
+			weights := hTaps.weights[x*hTaps.n : (x+1)*hTaps.n]
+			for c := 0; c < n; c++ {
+				sum := int32(0)
+				for k, sx := range taps {
+					sum += weights[k] * int32(row[sx*n+c])
+				}
+				out[x*n+c] = sum
+			}
+		}
+	}
+
+	// Vertical pass, descaling both passes at once.
+	const round = 1 << (2*resampleBits - 1)
+	for y := 0; y < dh; y++ {
+		taps := vTaps.index[y*vTaps.n : (y+1)*vTaps.n]
+		weights := vTaps.weights[y*vTaps.n : (y+1)*vTaps.n]
+		out := dst[y*dstStride:]
+		for i := 0; i < dw*n; i++ {
+			sum := int64(round)
+			for k, sy := range taps {
+				sum += int64(weights[k]) * int64(tmp[sy*dw*n+i])
+			}
+			out[i] = clampUint8(sum >> (2 * resampleBits))
+		}
+	}
+}
+
+// resamplePlane16 is like resamplePlane, for big-endian 16-bit channels. The
+// horizontal pass is kept in 64 bits, as 16-bit samples times the weights
+// may overflow 32 bits.
+func resamplePlane16(dst []byte, dstStride, dw, dh int, src []byte, srcStride, sw, sh, n int) {
+	if dw <= 0 || dh <= 0 || sw <= 0 || sh <= 0 {
+		return
+	}
+	hTaps, vTaps := makeTaps(dw, sw), makeTaps(dh, sh)
+
+	tmp := make([]int64, sh*dw*n)
+	for y := 0; y < sh; y++ {
+		row := src[y*srcStride:]
+		out := tmp[y*dw*n:]
+		for x := 0; x < dw; x++ {
+			taps := hTaps.index[x*hTaps.n : (x+1)*hTaps.n]
+			weights := hTaps.weights[x*hTaps.n : (x+1)*hTaps.n]
+			for c := 0; c < n; c++ {
+				sum := int64(0)
+				for k, sx := range taps {
+					i := 2 * (sx*n + c)
+					sum += int64(weights[k]) * int64(uint16(row[i])<<8|uint16(row[i+1]))
+				}
+				out[x*n+c] = sum
+			}
+		}
+	}
+
+	const round = 1 << (2*resampleBits - 1)
+	for y := 0; y < dh; y++ {
+		taps := vTaps.index[y*vTaps.n : (y+1)*vTaps.n]
+		weights := vTaps.weights[y*vTaps.n : (y+1)*vTaps.n]
+		out := dst[y*dstStride:]
+		for i := 0; i < dw*n; i++ {
+			sum := int64(round)
+			for k, sy := range taps {
+				sum += int64(weights[k]) * tmp[sy*dw*n+i]
+			}
+			v := min(max(sum>>(2*resampleBits), 0), 0xffff)
+			out[2*i] = uint8(v >> 8)
+			out[2*i+1] = uint8(v)
+		}
+	}
+}
+
+func clampUint8(x int64) uint8 {
+	if x < 0 {
+		return 0
+	}
+	if x > 255 {
+		return 255
+	}
+	return uint8(x)
+}
@@ -0,0 +1,116 @@
+package jpegscaled
+
+import (
+	"image"
+	"os"
+	"testing"
+)
+
+func TestFitSize(t *testing.T) {
+	testCases := []struct {
+		w, h   int
+		box    image.Point
+		fw, fh int
+		n      int
+	}{
+		{150, 103, image.Pt(40, 40), 40, 27, 3},
+		{150, 103, image.Pt(0, 40), 58, 40, 4},
+		{150, 103, image.Pt(75, 0), 75, 52, 5},
+		{150, 103, image.Pt(75, 51), 74, 51, 4},
+		{150, 103, image.Pt(18, 18), 18, 12, 1},
+		{150, 103, image.Pt(1, 1), 1, 1, 1},
+		{150, 103, image.Pt(200, 200), 200, 137, 11},
+		{150, 103, image.Pt(300, 300), 300, 206, maxDCTSize},
+		{150, 103, image.Pt(400, 400), 400, 275, maxDCTSize},
+		{150, 103, image.Pt(150, 103), 150, 103, DCTSIZE},
+		{103, 150, image.Pt(40, 40), 27, 40, 3},
+	}
+	for _, tc := range testCases {
+		fw, fh := fitSize(tc.w, tc.h, tc.box)
+		if fw != tc.fw || fh != tc.fh {
+			t.Errorf("fitSize(%d, %d, %v): got %dx%d, want %dx%d", tc.w, tc.h, tc.box, fw, fh, tc.fw, tc.fh)
+		}
+		if n := fitDCTSize(tc.w, tc.h, tc.box); n != tc.n {
+			t.Errorf("fitDCTSize(%d, %d, %v): got %d, want %d", tc.w, tc.h, tc.box, n, tc.n)
+		}
+	}
+}
+
+func TestDecodeFitTo(t *testing.T) {
+	for _, tc := range imageTests {
+		want, err := decodeStd(tc.goldenFilename + "#8.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, resample := range []bool{false, true} {
+			m, err := decodeFileWithOptions(tc.filename, DecodeOptions{FitTo: image.Pt(40, 40), Resample: resample})
+			if err != nil {
+				t.Errorf("%s: %v", tc.filename, err)
+				continue
+			}
+			wantBounds := image.Rect(0, 0, 56, 38)
+			if resample {
+				wantBounds = image.Rect(0, 0, 40, 27)
+			}
+			if m.Bounds() != wantBounds {
+				t.Errorf("%s (resample=%t): got bounds %v, want %v", tc.filename, resample, m.Bounds(), wantBounds)
+				continue
+			}
+			if resample {
+				if d := averageDelta(m, resize(toRGBA(want), 40, 27)); d > 12<<8 {
+					t.Errorf("%s: resampled image differs too much: %d", tc.filename, d)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeYCbCr(t *testing.T) {
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	} {
+		src := image.NewYCbCr(image.Rect(0, 0, 37, 23), ratio)
+		for i := range src.Y {
+			src.Y[i] = 200
+		}
+		for i := range src.Cb {
+			src.Cb[i], src.Cr[i] = 60, 180
+		}
+		for _, size := range []image.Point{{10, 7}, {37, 23}, {80, 50}} {
+			dst := resize(src, size.X, size.Y).(*image.YCbCr)
+			if dst.Bounds() != image.Rect(0, 0, size.X, size.Y) || dst.SubsampleRatio != ratio {
+				t.Errorf("%v %v: got bounds %v ratio %v", ratio, size, dst.Bounds(), dst.SubsampleRatio)
+				continue
+			}
+			// Resampling a flat image must leave it flat.
+			if got := dst.YCbCrAt(size.X-1, size.Y-1); got.Y != 200 || got.Cb != 60 || got.Cr != 180 {
+				t.Errorf("%v %v: got %v, want flat color", ratio, size, got)
+			}
+		}
+	}
+}
+
+func decodeFileWithOptions(filename string, opts DecodeOptions) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f, opts)
+}
+
+func toRGBA(m image.Image) *image.RGBA {
+	b := m.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(x-b.Min.X, y-b.Min.Y, m.At(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,234 @@
+package jpegscaled
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"io"
+	"math"
+)
+
+// RestartIndex locates the restart segments of a sequential JPEG image that
+// has a restart interval. Every segment can be decoded independently of the
+// others, so an index allows decoding tiles of a large image from an
+// [io.ReaderAt] without decoding everything before them.
+type RestartIndex struct {
+	// Width and Height are the image dimensions, in pixels.
+	Width, Height int
+	// MCUWidth and MCUHeight are the dimensions of an MCU, in pixels.
+	MCUWidth, MCUHeight int
+	// Interval is the restart interval: the number of MCUs per segment.
+	Interval int
+	// HeaderSize is the number of bytes before the entropy-coded data of
+	// the scan, i.e. up to and including the SOS segment.
+	HeaderSize int64
+	// Segments are the restart segments, in raster order.
+	Segments []RestartSegment
+}
+
+// RestartSegment is an independently decodable run of MCUs.
+type RestartSegment struct {
+	// Offset is the byte offset of the segment's entropy-coded data, just
+	// past the preceding RST marker or, for the first segment, the SOS
+	// segment.
+	Offset int64
+	// MCU is the position of the segment's first MCU, in units of MCUs.
+	MCU image.Point
+}
+
+// tileRun is a run of MCUs decoded for a tile. The run starts at a restart
+// segment whose data begins at offset.
+type tileRun struct {
+	start, end int
+	offset     int64
+}
+
+// BuildRestartIndex reads a JPEG image from r and returns the index of its
+// restart segments. The entropy-coded data is scanned for RST markers but not
+// decoded. Only sequential images with a restart interval and a single scan
+// covering all of their components are supported.
+func BuildRestartIndex(r io.Reader) (*RestartIndex, error) {
+	br := bufio.NewReader(r)
+	header, ns, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	d := decoder{headerOnly: true}
+	if _, err := d.decode(bytes.NewReader(header), false); err != nil {
+		return nil, err
+	}
+	if d.nComp == 0 {
+		return nil, FormatError("missing SOF marker")
+	}
+	if d.progressive {
+		return nil, UnsupportedError("restart index of a progressive JPEG")
+	}
+	if d.lossless {
+		return nil, UnsupportedError("restart index of a lossless JPEG")
+	}
+	if d.ri == 0 {
+		return nil, UnsupportedError("restart index of a JPEG without restart interval")
+	}
+	if ns != d.nComp {
+		return nil, UnsupportedError("restart index of a multi-scan JPEG")
+	}
+
+	x := &RestartIndex{
+		Width:      d.width,
+		Height:     d.height,
+		MCUWidth:   8 * d.hMax,
+		MCUHeight:  8 * d.vMax,
+		Interval:   d.ri,
+		HeaderSize: int64(len(header)),
+	}
+	mxx, total := x.mcuCounts()
+	n := (total + d.ri - 1) / d.ri
+	x.Segments = make([]RestartSegment, 0, n)
+	x.Segments = append(x.Segments, RestartSegment{Offset: x.HeaderSize})
+
+	// Scan the entropy-coded data for RST markers. Byte stuffing and fill
+	// bytes are specified in sections F.1.2.3 and B.1.1.2.
+	pos := x.HeaderSize
+	for len(x.Segments) < n {
+		c, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = FormatError("missing RST marker")
+			}
+			return nil, err
+		}
+		pos++
+		if c != 0xff {
+			continue
+		}
+		for c == 0xff {
+			if c, err = br.ReadByte(); err != nil {
+				if err == io.EOF {
+					err = FormatError("missing RST marker")
+				}
+				return nil, err
+			}
+			pos++
+		}
+		if c == 0x00 {
+			continue
+		}
+		if c < rst0Marker || rst7Marker < c {
+			return nil, FormatError("missing RST marker")
+		}
+		mcu := len(x.Segments) * d.ri
+		x.Segments = append(x.Segments, RestartSegment{
+			Offset: pos,
+			MCU:    image.Pt(mcu%mxx, mcu/mxx),
+		})
+	}
+	return x, nil
+}
+
+// readHeader reads the segments of a JPEG image up to and including the
+// first SOS segment. It returns their bytes and the number of components in
+// the scan.
+func readHeader(br *bufio.Reader) (header []byte, ns int, err error) {
+	var tmp [4]byte
+	if _, err := io.ReadFull(br, tmp[:2]); err != nil {
+		return nil, 0, err
+	}
+	if tmp[0] != 0xff || tmp[1] != soiMarker {
+		return nil, 0, FormatError("missing SOI marker")
+	}
+	header = append(header, tmp[:2]...)
+	for {
+		if _, err := io.ReadFull(br, tmp[:2]); err != nil {
+			return nil, 0, err
+		}
+		if tmp[0] != 0xff {
+			return nil, 0, FormatError("extraneous data in header")
+		}
+		for tmp[1] == 0xff {
+			if tmp[1], err = br.ReadByte(); err != nil {
+				return nil, 0, err
+			}
+		}
+		marker := tmp[1]
+		if marker == eoiMarker || (rst0Marker <= marker && marker <= rst7Marker) {
+			return nil, 0, FormatError("missing SOS marker")
+		}
+		if _, err := io.ReadFull(br, tmp[2:4]); err != nil {
+			return nil, 0, err
+		}
+		n := int(tmp[2])<<8 + int(tmp[3]) - 2
+		if n < 0 {
+			return nil, 0, FormatError("short segment length")
+		}
+		header = append(header, 0xff, marker, tmp[2], tmp[3])
+		start := len(header)
+		header = append(header, make([]byte, n)...)
+		if _, err := io.ReadFull(br, header[start:]); err != nil {
+			return nil, 0, err
+		}
+		if marker == sosMarker {
+			if n == 0 {
+				return nil, 0, FormatError("SOS has wrong length")
+			}
+			return header, int(header[start]), nil
+		}
+	}
+}
+
+// mcuCounts returns the number of MCUs per row and in the whole image.
+func (x *RestartIndex) mcuCounts() (mxx, total int) {
+	mxx = (x.Width + x.MCUWidth - 1) / x.MCUWidth
+	myy := (x.Height + x.MCUHeight - 1) / x.MCUHeight
+	return mxx, mxx * myy
+}
+
+// DecodeTile decodes the part of the image inside rect, in source pixel
+// coordinates, reading only the header and the restart segments that rect
+// intersects from r. r must hold the same JPEG image that the index was
+// built from. opts are applied as for [Decode], with Crop set to rect, so
+// the returned image's bounds are rect scaled by the DCT size.
+func (x *RestartIndex) DecodeTile(r io.ReaderAt, rect image.Rectangle, opts DecodeOptions) (image.Image, error) {
+	rect = rect.Intersect(image.Rect(0, 0, x.Width, x.Height))
+	if rect.Empty() {
+		return nil, FormatError("tile outside of the image")
+	}
+	opts.Crop = rect
+	d := newDecoder(opts)
+	d.tileSrc = r
+	d.tileRuns = x.tileRuns(rect)
+	return d.decodeImage(io.NewSectionReader(r, 0, x.HeaderSize), opts)
+}
+
+// tileRuns returns the runs of whole restart segments that cover the MCUs
+// intersecting rect, one per MCU row, merging the runs that touch.
+func (x *RestartIndex) tileRuns(rect image.Rectangle) []tileRun {
+	mxx, total := x.mcuCounts()
+	mx0, mx1 := rect.Min.X/x.MCUWidth, (rect.Max.X+x.MCUWidth-1)/x.MCUWidth
+	my0, my1 := rect.Min.Y/x.MCUHeight, (rect.Max.Y+x.MCUHeight-1)/x.MCUHeight
+	var runs []tileRun
+	for my := my0; my < my1; my++ {
+		s0 := (my*mxx + mx0) / x.Interval
+		s1 := (my*mxx + mx1 + x.Interval - 1) / x.Interval
+		start, end := s0*x.Interval, min(s1*x.Interval, total)
+		if len(runs) > 0 && runs[len(runs)-1].end >= start {
+			runs[len(runs)-1].end = max(runs[len(runs)-1].end, end)
+			continue
+		}
+		runs = append(runs, tileRun{start: start, end: end, offset: x.Segments[s0].Offset})
+	}
+	return runs
+}
+
+// decodeTileRuns decodes the runs of MCUs of a tile, reading each one from
+// its offset in d.tileSrc.
+func (d *decoder) decodeTileRuns(sh *scanHeader, mxx int) error {
+	for _, run := range d.tileRuns {
+		d.r = io.NewSectionReader(d.tileSrc, run.offset, math.MaxInt64-run.offset)
+		d.bytes.i, d.bytes.j, d.bytes.nUnreadable = 0, 0, 0
+		d.eobRun = 0
+		if err := d.decodeMCUs(sh, mxx, run.start, run.end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
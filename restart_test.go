@@ -0,0 +1,114 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestBuildRestartIndex(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.rst3.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := BuildRestartIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Width != 150 || x.Height != 103 || x.MCUWidth != 16 || x.MCUHeight != 16 || x.Interval != 3 {
+		t.Fatalf("got %dx%d, MCU %dx%d, interval %d", x.Width, x.Height, x.MCUWidth, x.MCUHeight, x.Interval)
+	}
+	// 10x7 MCUs, 3 per segment.
+	if len(x.Segments) != 24 {
+		t.Fatalf("got %d segments, want 24", len(x.Segments))
+	}
+	if x.Segments[0].Offset != x.HeaderSize || x.Segments[0].MCU != (image.Point{}) {
+		t.Errorf("first segment offset %d, header size %d", x.Segments[0].Offset, x.HeaderSize)
+	}
+	for i, s := range x.Segments[1:] {
+		if m := data[s.Offset-1]; data[s.Offset-2] != 0xff || m != rst0Marker+uint8(i%8) {
+			t.Errorf("segment %d: offset %d does not follow RST%d", i+1, s.Offset, i%8)
+		}
+		if want := image.Pt((i+1)*3%10, (i+1)*3/10); s.MCU != want {
+			t.Errorf("segment %d: got MCU %v, want %v", i+1, s.MCU, want)
+		}
+	}
+
+	if _, err := BuildRestartIndex(bytes.NewReader(data[:len(data)/2])); err == nil {
+		t.Error("truncated image: got nil error")
+	}
+	for _, filename := range []string{"testdata/video-001.jpeg", "testdata/video-001.progressive.jpeg"} {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := BuildRestartIndex(f); err == nil {
+			t.Errorf("%s: got nil error", filename)
+		}
+		f.Close()
+	}
+}
+
+func TestDecodeTile(t *testing.T) {
+	for _, filename := range []string{
+		"testdata/video-001.restart2.jpeg",
+		"testdata/video-001.rst3.jpeg",
+		"testdata/video-005.gray.rst5.jpeg",
+		"testdata/video-005.gray.q50.arith.rst5.jpeg",
+	} {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := BuildRestartIndex(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{8, 3, 16} {
+			for _, rect := range []image.Rectangle{
+				image.Rect(0, 0, 150, 103),
+				image.Rect(0, 0, 16, 16),
+				image.Rect(40, 20, 60, 70),
+				image.Rect(100, 90, 150, 103),
+				image.Rect(130, 0, 150, 103),
+			} {
+				opts := DecodeOptions{DCTSizeScaled: size, Crop: rect}
+				want, err := decodeFileWithOptions(filename, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m, err := x.DecodeTile(bytes.NewReader(data), rect, opts)
+				if err != nil {
+					t.Errorf("%s #%d %v: %v", filename, size, rect, err)
+					continue
+				}
+				if err := equalImages(m, want); err != nil {
+					t.Errorf("%s #%d %v: %v", filename, size, rect, err)
+				}
+			}
+		}
+		if _, err := x.DecodeTile(bytes.NewReader(data), image.Rect(200, 0, 300, 10), DecodeOptions{}); err == nil {
+			t.Errorf("%s: tile outside of the image: got nil error", filename)
+		}
+	}
+}
+
+func TestTileRuns(t *testing.T) {
+	// 10x7 MCUs of 16x16 pixels, 3 MCUs per segment.
+	x := &RestartIndex{Width: 150, Height: 103, MCUWidth: 16, MCUHeight: 16, Interval: 3}
+	for i := 0; i < 24; i++ {
+		x.Segments = append(x.Segments, RestartSegment{Offset: int64(100 * i)})
+	}
+	got := x.tileRuns(image.Rect(40, 20, 60, 70))
+	// MCU columns 2-3 of rows 1-4 are MCUs 12-13, 22-23, 32-33 and 42-43.
+	want := []tileRun{{12, 15, 400}, {21, 24, 700}, {30, 36, 1000}, {42, 45, 1400}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("run %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
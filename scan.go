@@ -8,54 +8,139 @@ import (
 	"image"
 )
 
-// makeImg allocates and initializes the destination image.
-func (d *decoder) makeImg(mxx, myy int) {
-	if d.dctSizeScaled <= 0 || d.dctSizeScaled > 8 {
-		d.dctSizeScaled = DCTSIZE
+// makeImg allocates and initializes the destination image. Only the MCUs
+// intersecting the crop rectangle are allocated.
+func (d *decoder) makeImg() error {
+	full := image.Rect(0, 0, d.width, d.height)
+	if d.crop.Empty() {
+		d.crop = full
+	} else if d.crop = d.crop.Intersect(full); d.crop.Empty() {
+		return FormatError("crop rectangle outside of the image")
 	}
-	scaledWidth := d.width * d.dctSizeScaled / DCTSIZE
+	if d.autoOrient && transposes(d.orientation) {
+		// FitTo is the box of the oriented image.
+		d.fitTo.X, d.fitTo.Y = d.fitTo.Y, d.fitTo.X
+	}
+	if d.fitTo != (image.Point{}) {
+		d.dctSizeScaledX = fitDCTSize(d.crop.Dx(), d.crop.Dy(), d.fitTo)
+		d.dctSizeScaledY = d.dctSizeScaledX
+	}
+	if d.dctSizeScaledX <= 0 || d.dctSizeScaledX > maxDCTSize {
+		d.dctSizeScaledX = DCTSIZE
+	}
+	if d.dctSizeScaledY <= 0 || d.dctSizeScaledY > maxDCTSize {
+		d.dctSizeScaledY = DCTSIZE
+	}
+	scaledWidth := d.width * d.dctSizeScaledX / DCTSIZE
 	if scaledWidth <= 0 {
 		scaledWidth = 1
 	}
-	scaledHeight := d.height * d.dctSizeScaled / DCTSIZE
+	scaledHeight := d.height * d.dctSizeScaledY / DCTSIZE
 	if scaledHeight <= 0 {
 		scaledHeight = 1
 	}
+
+	// cropScaled is the crop rectangle in scaled coordinates, rounded out and
+	// at least one pixel large. cropMCU is the MCUs covering it, and r is
+	// their part of the scaled image.
+	minX := min(d.crop.Min.X*d.dctSizeScaledX/DCTSIZE, scaledWidth-1)
+	minY := min(d.crop.Min.Y*d.dctSizeScaledY/DCTSIZE, scaledHeight-1)
+	maxX := max(min((d.crop.Max.X*d.dctSizeScaledX+DCTSIZE-1)/DCTSIZE, scaledWidth), minX+1)
+	maxY := max(min((d.crop.Max.Y*d.dctSizeScaledY+DCTSIZE-1)/DCTSIZE, scaledHeight), minY+1)
+	d.cropScaled = image.Rect(minX, minY, maxX, maxY)
+	mw, mh := d.dctSizeScaledX*d.hMax, d.dctSizeScaledY*d.vMax
+	d.cropMCU = image.Rect(minX/mw, minY/mh, (maxX+mw-1)/mw, (maxY+mh-1)/mh)
+	r := image.Rect(d.cropMCU.Min.X*mw, d.cropMCU.Min.Y*mh, d.cropMCU.Max.X*mw, d.cropMCU.Max.Y*mh)
+	visible := r.Intersect(image.Rect(0, 0, scaledWidth, scaledHeight))
+
 	if d.nComp == 1 {
-		m := image.NewGray(image.Rect(0, 0, d.dctSizeScaled*mxx, d.dctSizeScaled*myy))
-		d.img1 = m.SubImage(image.Rect(0, 0, scaledWidth, scaledHeight)).(*image.Gray)
-		return
+		if d.precision > 8 {
+			d.img16[0] = image.NewGray16(r).SubImage(visible).(*image.Gray16)
+			return nil
+		}
+		m := image.NewGray(r)
+		d.img1 = m.SubImage(visible).(*image.Gray)
+		return nil
+	}
+
+	if d.nComp != 3 && d.nComp != 4 {
+		return d.makePlanar(r, visible)
+	}
+
+	hRatio := d.hMax / d.comp[1].h
+	vRatio := d.vMax / d.comp[1].v
+	if d.upsample {
+		// Every component is upsampled to the full resolution instead.
+		d.fullChroma = false
+		hRatio, vRatio = 1, 1
+		d.makePlanes()
+	}
+	if d.fullChroma && (d.dctSizeScaledX*hRatio > maxDCTSize || d.dctSizeScaledY*vRatio > maxDCTSize) {
+		// The chroma IDCT would have to be larger than we support.
+		d.fullChroma = false
+	}
+	if d.fullChroma {
+		hRatio, vRatio = 1, 1
+	}
+	if d.precision > 8 {
+		if d.nComp == 4 {
+			return UnsupportedError("12-bit precision with 4 components")
+		}
+		d.img16[0] = image.NewGray16(r).SubImage(visible).(*image.Gray16)
+		// r is made of whole MCUs, so its chroma rectangle is exact.
+		c := image.Rect(r.Min.X/hRatio, r.Min.Y/vRatio, r.Max.X/hRatio, r.Max.Y/vRatio)
+		d.img16[1] = image.NewGray16(c)
+		d.img16[2] = image.NewGray16(c)
+		return nil
+	}
+	ratio, _ := subsampleRatio(hRatio, vRatio)
+	m := image.NewYCbCr(r, ratio)
+	d.img3 = m.SubImage(visible).(*image.YCbCr)
+
+	if d.nComp == 4 {
+		// The K component always has the same sampling factors as Y.
+		d.blackPix = make([]byte, r.Dx()*r.Dy())
+		d.blackStride = r.Dx()
 	}
+	return nil
+}
 
-	h0 := d.comp[0].h
-	v0 := d.comp[0].v
-	hRatio := h0 / d.comp[1].h
-	vRatio := v0 / d.comp[1].v
-	var subsampleRatio image.YCbCrSubsampleRatio
+// subsampleRatio returns the subsample ratio of an image whose luma has
+// hRatio and vRatio times the samples of its chroma, in each direction. It
+// returns false if image.YCbCr has no such ratio.
+func subsampleRatio(hRatio, vRatio int) (image.YCbCrSubsampleRatio, bool) {
 	switch hRatio<<4 | vRatio {
 	case 0x11:
-		subsampleRatio = image.YCbCrSubsampleRatio444
+		return image.YCbCrSubsampleRatio444, true
 	case 0x12:
-		subsampleRatio = image.YCbCrSubsampleRatio440
+		return image.YCbCrSubsampleRatio440, true
 	case 0x21:
-		subsampleRatio = image.YCbCrSubsampleRatio422
+		return image.YCbCrSubsampleRatio422, true
 	case 0x22:
-		subsampleRatio = image.YCbCrSubsampleRatio420
+		return image.YCbCrSubsampleRatio420, true
 	case 0x41:
-		subsampleRatio = image.YCbCrSubsampleRatio411
+		return image.YCbCrSubsampleRatio411, true
 	case 0x42:
-		subsampleRatio = image.YCbCrSubsampleRatio410
-	default:
-		panic("unreachable")
+		return image.YCbCrSubsampleRatio410, true
 	}
-	m := image.NewYCbCr(image.Rect(0, 0, d.dctSizeScaled*h0*mxx, d.dctSizeScaled*v0*myy), subsampleRatio)
-	d.img3 = m.SubImage(image.Rect(0, 0, scaledWidth, scaledHeight)).(*image.YCbCr)
+	return image.YCbCrSubsampleRatio444, false
+}
 
-	if d.nComp == 4 {
-		h3, v3 := d.comp[3].h, d.comp[3].v
-		d.blackPix = make([]byte, d.dctSizeScaled*h3*mxx*d.dctSizeScaled*v3*myy)
-		d.blackStride = d.dctSizeScaled * h3 * mxx
-	}
+// scanComponent is a component of a scan, as specified in section B.2.3.
+type scanComponent struct {
+	compIndex uint8
+	td        uint8 // DC table selector.
+	ta        uint8 // AC table selector.
+}
+
+// scanHeader holds the parameters of a scan, parsed from its SOS segment.
+// The fields have the same meaning as the processSOS variables of the same
+// name.
+type scanHeader struct {
+	nComp            int
+	comp             [maxComponents]scanComponent
+	zigStart, zigEnd int32
+	ah, al           uint32
 }
 
 // Specified in section B.2.3.
@@ -63,7 +148,7 @@ func (d *decoder) processSOS(n int) error {
 	if d.nComp == 0 {
 		return FormatError("missing SOF marker")
 	}
-	if n < 6 || 4+2*d.nComp < n || n%2 != 0 {
+	if n < 6 || 4+2*min(d.nComp, maxComponents) < n || n%2 != 0 {
 		return FormatError("SOS has wrong length")
 	}
 	if err := d.readFull(d.tmp[:n]); err != nil {
@@ -73,16 +158,12 @@ func (d *decoder) processSOS(n int) error {
 	if n != 4+2*nComp {
 		return FormatError("SOS length inconsistent with number of components")
 	}
-	var scan [maxComponents]struct {
-		compIndex uint8
-		td        uint8 // DC table selector.
-		ta        uint8 // AC table selector.
-	}
+	var scan [maxComponents]scanComponent
 	totalHV := 0
 	for i := 0; i < nComp; i++ {
 		cs := d.tmp[1+2*i] // Component selector.
 		compIndex := -1
-		for j, comp := range d.comp[:d.nComp] {
+		for j, comp := range d.comp {
 			if cs == comp.c {
 				compIndex = j
 			}
@@ -118,6 +199,9 @@ func (d *decoder) processSOS(n int) error {
 	if d.nComp > 1 && totalHV > 10 {
 		return FormatError("total sampling factors too large")
 	}
+	if !d.arithmetic {
+		d.useStandardHuffman(scan[:nComp])
+	}
 
 	// zigStart and zigEnd are the spectral selection bounds.
 	// ah and al are the successive approximation high and low values.
@@ -137,7 +221,19 @@ func (d *decoder) processSOS(n int) error {
 	// For sequential JPEGs, these parameters are hard-coded to 0/63/0/0, as
 	// per table B.3.
 	zigStart, zigEnd, ah, al := int32(0), int32(blockSize-1), uint32(0), uint32(0)
-	if d.progressive {
+	if d.lossless {
+		// For lossless images, Ss is the predictor selection value and Al the
+		// point transform, as per section H.2.2. Predictor 0 is only used by
+		// hierarchical images.
+		zigStart = int32(d.tmp[1+2*nComp])
+		al = uint32(d.tmp[3+2*nComp] & 0x0f)
+		if zigStart < 1 || zigStart > 7 {
+			return FormatError("bad lossless predictor")
+		}
+		if int(al) >= d.precision {
+			return FormatError("bad point transform")
+		}
+	} else if d.progressive {
 		zigStart = int32(d.tmp[1+2*nComp])
 		zigEnd = int32(d.tmp[2+2*nComp])
 		ah = uint32(d.tmp[3+2*nComp] >> 4)
@@ -154,191 +250,244 @@ func (d *decoder) processSOS(n int) error {
 	}
 
 	// mxx and myy are the number of MCUs (Minimum Coded Units) in the image.
-	h0, v0 := d.comp[0].h, d.comp[0].v // The h and v values from the Y components.
-	mxx := (d.width + 8*h0 - 1) / (8 * h0)
-	myy := (d.height + 8*v0 - 1) / (8 * v0)
-	if d.img1 == nil && d.img3 == nil {
-		d.makeImg(mxx, myy)
+	mxx := (d.width + 8*d.hMax - 1) / (8 * d.hMax)
+	myy := (d.height + 8*d.vMax - 1) / (8 * d.vMax)
+	if d.img1 == nil && d.img3 == nil && d.img16[0] == nil && d.imgPlanar == nil {
+		if err := d.makeImg(); err != nil {
+			return err
+		}
 	}
 	if d.progressive {
 		for i := 0; i < nComp; i++ {
 			compIndex := scan[i].compIndex
 			if d.progCoeffs[compIndex] == nil {
-				d.progCoeffs[compIndex] = make([]block, mxx*myy*d.comp[compIndex].h*d.comp[compIndex].v)
+				w, h := d.blockSize(int(compIndex))
+				d.progCoeffs[compIndex] = newCoeffStore(mxx*myy*d.comp[compIndex].h*d.comp[compIndex].v, w, h)
 			}
 		}
 	}
 
+	sh := &scanHeader{nComp: nComp, comp: scan, zigStart: zigStart, zigEnd: zigEnd, ah: ah, al: al}
+	if d.lossless {
+		if d.samples[0] == nil {
+			d.makeSamplePlanes()
+		}
+		return d.decodeLossless(sh)
+	}
+	if d.tileRuns != nil {
+		return d.decodeTileRuns(sh, mxx)
+	}
+	if d.concurrency > 1 && d.ri > 0 && !d.progressive {
+		return d.decodeMCUsConcurrently(sh, mxx, mxx*myy)
+	}
+	if err := d.decodeMCUs(sh, mxx, 0, mxx*myy); err != nil {
+		return err
+	}
+	if d.progressive {
+		d.recordScan(sh)
+	}
+	return nil
+}
+
+// decodeMCUs decodes the MCUs of a scan from start (inclusive) to end
+// (exclusive), in raster order. mxx is the number of MCUs per row. The
+// entropy-coded data of MCU start must be next in the input, so start must
+// be 0 or the first MCU of a restart interval.
+func (d *decoder) decodeMCUs(sh *scanHeader, mxx, start, end int) error {
+	nComp, scan := sh.nComp, &sh.comp
+	zigStart, zigEnd, ah, al := sh.zigStart, sh.zigEnd, sh.ah, sh.al
+
 	d.bits = bits{}
-	mcu, expectedRST := 0, uint8(rst0Marker)
+	if d.arithmetic {
+		d.resetArith()
+	}
+	mcu, expectedRST := start, uint8(rst0Marker)
+	if d.ri > 0 {
+		expectedRST += uint8(start / d.ri % 8)
+	}
 	var (
 		// b is the decoded coefficients, in natural (not zig-zag) order.
-		b  block
+		b block
+		// dc is the DC prediction of every component of the scan.
 		dc [maxComponents]int32
 		// bx and by are the location of the current block, in units of 8x8
 		// blocks: the third block in the first row has (bx, by) = (2, 0).
 		bx, by     int
 		blockCount int
 	)
-	for my := 0; my < myy; my++ {
-		for mx := 0; mx < mxx; mx++ {
-			for i := 0; i < nComp; i++ {
-				compIndex := scan[i].compIndex
-				hi := d.comp[compIndex].h
-				vi := d.comp[compIndex].v
-				for j := 0; j < hi*vi; j++ {
-					// The blocks are traversed one MCU at a time. For 4:2:0 chroma
-					// subsampling, there are four Y 8x8 blocks in every 16x16 MCU.
-					//
-					// For a sequential 32x16 pixel image, the Y blocks visiting order is:
-					//	0 1 4 5
-					//	2 3 6 7
-					//
-					// For progressive images, the interleaved scans (those with nComp > 1)
-					// are traversed as above, but non-interleaved scans are traversed left
-					// to right, top to bottom:
-					//	0 1 2 3
-					//	4 5 6 7
-					// Only DC scans (zigStart == 0) can be interleaved. AC scans must have
-					// only one component.
-					//
-					// To further complicate matters, for non-interleaved scans, there is no
-					// data for any blocks that are inside the image at the MCU level but
-					// outside the image at the pixel level. For example, a 24x16 pixel 4:2:0
-					// progressive image consists of two 16x16 MCUs. The interleaved scans
-					// will process 8 Y blocks:
-					//	0 1 4 5
-					//	2 3 6 7
-					// The non-interleaved scans will process only 6 Y blocks:
-					//	0 1 2
-					//	3 4 5
-					if nComp != 1 {
-						bx = hi*mx + j%hi
-						by = vi*my + j/hi
-					} else {
-						q := mxx * hi
-						bx = blockCount % q
-						by = blockCount / q
-						blockCount++
-						if bx*8 >= d.width || by*8 >= d.height {
-							continue
-						}
+	if nComp == 1 {
+		// Non-interleaved scans count blocks rather than MCUs.
+		c := &d.comp[scan[0].compIndex]
+		blockCount = start * c.h * c.v
+	}
+	for mcu < end {
+		mx, my := mcu%mxx, mcu/mxx
+		for i := 0; i < nComp; i++ {
+			compIndex := scan[i].compIndex
+			hi := d.comp[compIndex].h
+			vi := d.comp[compIndex].v
+			for j := 0; j < hi*vi; j++ {
+				// The blocks are traversed one MCU at a time. For 4:2:0 chroma
+				// subsampling, there are four Y 8x8 blocks in every 16x16 MCU.
+				//
+				// For a sequential 32x16 pixel image, the Y blocks visiting order is:
+				//	0 1 4 5
+				//	2 3 6 7
+				//
+				// For progressive images, the interleaved scans (those with nComp > 1)
+				// are traversed as above, but non-interleaved scans are traversed left
+				// to right, top to bottom:
+				//	0 1 2 3
+				//	4 5 6 7
+				// Only DC scans (zigStart == 0) can be interleaved. AC scans must have
+				// only one component.
+				//
+				// To further complicate matters, for non-interleaved scans, there is no
+				// data for any blocks that are inside the image at the MCU level but
+				// outside the image at the pixel level. For example, a 24x16 pixel 4:2:0
+				// progressive image consists of two 16x16 MCUs. The interleaved scans
+				// will process 8 Y blocks:
+				//	0 1 4 5
+				//	2 3 6 7
+				// The non-interleaved scans will process only 6 Y blocks:
+				//	0 1 2
+				//	3 4 5
+				if nComp != 1 {
+					bx = hi*mx + j%hi
+					by = vi*my + j/hi
+				} else {
+					q := mxx * hi
+					bx = blockCount % q
+					by = blockCount / q
+					blockCount++
+					if !d.blockInFrame(bx, by, int(compIndex)) {
+						continue
 					}
+				}
 
-					// Load the previous partially decoded coefficients, if applicable.
-					if d.progressive {
-						b = d.progCoeffs[compIndex][by*mxx*hi+bx]
-					} else {
-						b = block{}
-					}
+				// Load the previous partially decoded coefficients, if applicable.
+				if d.progressive {
+					d.progCoeffs[compIndex].load(by*mxx*hi+bx, &b, true)
+				} else {
+					b = block{}
+				}
 
-					if ah != 0 {
-						if err := d.refine(&b, &d.huff[acTable][scan[i].ta], zigStart, zigEnd, 1<<al); err != nil {
+				if d.arithmetic {
+					if err := d.decodeArithBlock(&b, sh, i, &dc[i]); err != nil {
+						return err
+					}
+				} else if ah != 0 {
+					if err := d.refine(&b, &d.huff[acTable][scan[i].ta], zigStart, zigEnd, 1<<al); err != nil {
+						return err
+					}
+				} else {
+					zig := zigStart
+					if zig == 0 {
+						zig++
+						// Decode the DC coefficient, as specified in section F.2.2.1.
+						value, err := d.decodeHuffman(&d.huff[dcTable][scan[i].td])
+						if err != nil {
 							return err
 						}
+						if value > 16 {
+							return UnsupportedError("excessive DC component")
+						}
+						dcDelta, err := d.receiveExtend(value)
+						if err != nil {
+							return err
+						}
+						dc[i] += dcDelta
+						b[0] = dc[i] << al
+					}
+
+					if zig <= zigEnd && d.eobRun > 0 {
+						d.eobRun--
 					} else {
-						zig := zigStart
-						if zig == 0 {
-							zig++
-							// Decode the DC coefficient, as specified in section F.2.2.1.
-							value, err := d.decodeHuffman(&d.huff[dcTable][scan[i].td])
+						// Decode the AC coefficients, as specified in section F.2.2.2.
+						huff := &d.huff[acTable][scan[i].ta]
+						for ; zig <= zigEnd; zig++ {
+							value, err := d.decodeHuffman(huff)
 							if err != nil {
 								return err
 							}
-							if value > 16 {
-								return UnsupportedError("excessive DC component")
-							}
-							dcDelta, err := d.receiveExtend(value)
-							if err != nil {
-								return err
-							}
-							dc[compIndex] += dcDelta
-							b[0] = dc[compIndex] << al
-						}
-
-						if zig <= zigEnd && d.eobRun > 0 {
-							d.eobRun--
-						} else {
-							// Decode the AC coefficients, as specified in section F.2.2.2.
-							huff := &d.huff[acTable][scan[i].ta]
-							for ; zig <= zigEnd; zig++ {
-								value, err := d.decodeHuffman(huff)
+							val0 := value >> 4
+							val1 := value & 0x0f
+							if val1 != 0 {
+								zig += int32(val0)
+								if zig > zigEnd {
+									break
+								}
+								ac, err := d.receiveExtend(val1)
 								if err != nil {
 									return err
 								}
-								val0 := value >> 4
-								val1 := value & 0x0f
-								if val1 != 0 {
-									zig += int32(val0)
-									if zig > zigEnd {
-										break
-									}
-									ac, err := d.receiveExtend(val1)
-									if err != nil {
-										return err
-									}
-									b[unzig[zig]] = ac << al
-								} else {
-									if val0 != 0x0f {
-										d.eobRun = uint16(1 << val0)
-										if val0 != 0 {
-											bits, err := d.decodeBits(int32(val0))
-											if err != nil {
-												return err
-											}
-											d.eobRun |= uint16(bits)
+								b[unzig[zig]] = ac << al
+							} else {
+								if val0 != 0x0f {
+									d.eobRun = uint16(1 << val0)
+									if val0 != 0 {
+										bits, err := d.decodeBits(int32(val0))
+										if err != nil {
+											return err
 										}
-										d.eobRun--
-										break
+										d.eobRun |= uint16(bits)
 									}
-									zig += 0x0f
+									d.eobRun--
+									break
 								}
+								zig += 0x0f
 							}
 						}
 					}
+				}
 
-					if d.progressive {
-						// Save the coefficients.
-						d.progCoeffs[compIndex][by*mxx*hi+bx] = b
-						// At this point, we could call reconstructBlock to dequantize and perform the
-						// inverse DCT, to save early stages of a progressive image to the *image.YCbCr
-						// buffers (the whole point of progressive encoding), but in Go, the jpeg.Decode
-						// function does not return until the entire image is decoded, so we "continue"
-						// here to avoid wasted computation. Instead, reconstructBlock is called on each
-						// accumulated block by the reconstructProgressiveImage method after all of the
-						// SOS markers are processed.
-						continue
-					}
-					if err := d.reconstructBlock(&b, bx, by, int(compIndex)); err != nil {
-						return err
-					}
-				} // for j
-			} // for i
-			mcu++
-			if d.ri > 0 && mcu%d.ri == 0 && mcu < mxx*myy {
-				// For well-formed input, the RST[0-7] restart marker follows
-				// immediately. For corrupt input, call findRST to try to
-				// resynchronize.
-				if err := d.readFull(d.tmp[:2]); err != nil {
+				if d.progressive {
+					// Save the coefficients.
+					d.progCoeffs[compIndex].store(by*mxx*hi+bx, &b)
+					// At this point, we could call reconstructBlock to dequantize and perform the
+					// inverse DCT, to save early stages of a progressive image to the *image.YCbCr
+					// buffers (the whole point of progressive encoding), but Decode does not return
+					// until the entire image is decoded, so we "continue" here to avoid wasted
+					// computation. Instead, reconstructBlock is called on each accumulated block by
+					// the reconstructProgressiveImage method after all of the SOS markers are
+					// processed, or after each of them if DecodeOptions.OnScan is set.
+					continue
+				}
+				if !d.blockInCrop(bx, by, int(compIndex)) {
+					continue
+				}
+				if err := d.reconstructBlock(&b, bx, by, int(compIndex)); err != nil {
 					return err
-				} else if d.tmp[0] != 0xff || d.tmp[1] != expectedRST {
-					if err := d.findRST(expectedRST); err != nil {
-						return err
-					}
 				}
-				expectedRST++
-				if expectedRST == rst7Marker+1 {
-					expectedRST = rst0Marker
+			} // for j
+		} // for i
+		mcu++
+		if d.ri > 0 && mcu%d.ri == 0 && mcu < end {
+			// For well-formed input, the RST[0-7] restart marker follows
+			// immediately. For corrupt input, call findRST to try to
+			// resynchronize.
+			if err := d.readFull(d.tmp[:2]); err != nil {
+				return err
+			} else if d.tmp[0] != 0xff || d.tmp[1] != expectedRST {
+				if err := d.findRST(expectedRST); err != nil {
+					return err
 				}
-				// Reset the Huffman decoder.
-				d.bits = bits{}
-				// Reset the DC components, as per section F.2.1.3.1.
-				dc = [maxComponents]int32{}
-				// Reset the progressive decoder state, as per section G.1.2.2.
-				d.eobRun = 0
 			}
-		} // for mx
-	} // for my
+			expectedRST++
+			if expectedRST == rst7Marker+1 {
+				expectedRST = rst0Marker
+			}
+			// Reset the Huffman or arithmetic decoder.
+			d.bits = bits{}
+			if d.arithmetic {
+				d.resetArith()
+			}
+			// Reset the DC components, as per section F.2.1.3.1.
+			clear(dc[:nComp])
+			// Reset the progressive decoder state, as per section G.1.2.2.
+			d.eobRun = 0
+		}
+	} // for mcu
 
 	return nil
 }
@@ -450,75 +599,160 @@ func (d *decoder) refineNonZeroes(b *block, zig, zigEnd, nz, delta int32) (int32
 }
 
 func (d *decoder) reconstructProgressiveImage() error {
-	// The h0, mxx, by and bx variables have the same meaning as in the
+	// The mxx, by and bx variables have the same meaning as in the
 	// processSOS method.
-	h0 := d.comp[0].h
-	mxx := (d.width + 8*h0 - 1) / (8 * h0)
+	mxx := (d.width + 8*d.hMax - 1) / (8 * d.hMax)
+	// Every row of blocks of every component is reconstructed separately,
+	// possibly concurrently, as they write to disjoint parts of the image.
+	// Only the blocks of the MCUs intersecting the crop rectangle are
+	// reconstructed.
+	type blockRow struct{ compIndex, by int }
+	var rows []blockRow
 	for i := 0; i < d.nComp; i++ {
 		if d.progCoeffs[i] == nil {
 			continue
 		}
-		v := 8 * d.comp[0].v / d.comp[i].v
-		h := 8 * d.comp[0].h / d.comp[i].h
-		stride := mxx * d.comp[i].h
-		for by := 0; by*v < d.height; by++ {
-			for bx := 0; bx*h < d.width; bx++ {
-				if err := d.reconstructBlock(&d.progCoeffs[i][by*stride+bx], bx, by, i); err != nil {
+		vi := d.comp[i].v
+		for by := d.cropMCU.Min.Y * vi; by < d.cropMCU.Max.Y*vi && d.blockInFrame(0, by, i); by++ {
+			rows = append(rows, blockRow{i, by})
+		}
+	}
+	return d.forEach(len(rows), func() func(k int) error {
+		var b block
+		return func(k int) error {
+			i, by := rows[k].compIndex, rows[k].by
+			hi := d.comp[i].h
+			stride := mxx * hi
+			for bx := d.cropMCU.Min.X * hi; bx < d.cropMCU.Max.X*hi && d.blockInFrame(bx, by, i); bx++ {
+				d.progCoeffs[i].load(by*stride+bx, &b, false)
+				if err := d.reconstructBlock(&b, bx, by, i); err != nil {
 					return err
 				}
 			}
+			return nil
 		}
+	})
+}
+
+// blockInFrame returns whether the block at (bx, by), in units of the
+// component's blocks, holds any of the component's samples. As per section
+// A.1.1, a component has ceil(width * h / hMax) samples per row, and
+// similarly for its rows.
+func (d *decoder) blockInFrame(bx, by, compIndex int) bool {
+	c := &d.comp[compIndex]
+	return bx*8*d.hMax < d.width*c.h && by*8*d.vMax < d.height*c.v
+}
+
+// blockInCrop returns whether the block at (bx, by), in units of the
+// component's blocks, belongs to an MCU that intersects the crop rectangle.
+func (d *decoder) blockInCrop(bx, by, compIndex int) bool {
+	hi, vi := d.comp[compIndex].h, d.comp[compIndex].v
+	return image.Pt(bx/hi, by/vi).In(d.cropMCU)
+}
+
+// blockSize returns the IDCT output size of a block of the given component.
+// With fullChroma, subsampled components use a proportionally larger IDCT, so
+// that every component ends up at the luma resolution.
+func (d *decoder) blockSize(compIndex int) (w, h int) {
+	if !d.fullChroma {
+		return d.dctSizeScaledX, d.dctSizeScaledY
 	}
-	return nil
+	c := &d.comp[compIndex]
+	return d.dctSizeScaledX * d.hMax / c.h, d.dctSizeScaledY * d.vMax / c.v
 }
 
 // reconstructBlock dequantizes, performs the inverse DCT and stores the block
 // to the image.
 func (d *decoder) reconstructBlock(b *block, bx, by, compIndex int) error {
 	qt := &d.quant[d.comp[compIndex].tq]
-	switch d.dctSizeScaled {
-	case 7:
+	// pix holds the IDCT output, w samples per row. The square
+	// 8x8 and smaller IDCTs work in place, but rectangular or larger outputs
+	// do not fit into b. The in-place IDCTs only handle 8-bit samples.
+	w, h := d.blockSize(compIndex)
+	pix := b[:]
+	switch {
+	case w != h || w > DCTSIZE || d.precision != 8:
+		var large [maxDCTSize * maxDCTSize]int32
+		pix = large[:w*h]
+		jpeg_idct_scaled(b, qt, pix, w, h, d.precision)
+	case w == 8:
+		idct_slow(b, qt)
+	case w == 7:
 		jpeg_idct_7x7(b, qt)
-	case 6:
+	case w == 6:
 		jpeg_idct_6x6(b, qt)
-	case 5:
+	case w == 5:
 		jpeg_idct_5x5(b, qt)
-	case 4:
+	case w == 4:
 		jpeg_idct_4x4(b, qt)
-	case 3:
+	case w == 3:
 		jpeg_idct_3x3(b, qt)
-	case 2:
+	case w == 2:
 		jpeg_idct_2x2(b, qt)
-	case 1:
+	case w == 1:
 		jpeg_idct_1x1(b, qt)
-	default:
-		d.dctSizeScaled = DCTSIZE
-		idct_slow(b, qt)
+	}
+	return d.storeBlock(pix, w, h, bx, by, compIndex)
+}
+
+// storeBlock stores the w x h samples in pix, w per row, of the block at
+// (bx, by) to the image. Samples with more than 8 bits of precision are
+// scaled to 16 bits.
+func (d *decoder) storeBlock(pix []int32, w, h, bx, by, compIndex int) error {
+	// Make (bx, by) relative to the first allocated block.
+	bx -= d.cropMCU.Min.X * d.comp[compIndex].h
+	by -= d.cropMCU.Min.Y * d.comp[compIndex].v
+	// Samples with more than 8 bits take 2 bytes.
+	n := 1
+	if d.precision > 8 {
+		n = 2
 	}
 	dst, stride := []byte(nil), 0
-	if d.nComp == 1 {
-		dst, stride = d.img1.Pix[d.dctSizeScaled*(by*d.img1.Stride+bx):], d.img1.Stride
-	} else {
+	switch {
+	case d.upsample:
+		stride = d.planeStride[compIndex]
+		dst = d.planes[compIndex][h*by*stride+n*w*bx:]
+	case d.precision > 8:
+		m := d.img16[compIndex]
+		dst, stride = m.Pix[h*by*m.Stride+2*w*bx:], m.Stride
+	case d.nComp == 1:
+		dst, stride = d.img1.Pix[h*by*d.img1.Stride+w*bx:], d.img1.Stride
+	default:
 		switch compIndex {
 		case 0:
-			dst, stride = d.img3.Y[d.dctSizeScaled*(by*d.img3.YStride+bx):], d.img3.YStride
+			dst, stride = d.img3.Y[h*by*d.img3.YStride+w*bx:], d.img3.YStride
 		case 1:
-			dst, stride = d.img3.Cb[d.dctSizeScaled*(by*d.img3.CStride+bx):], d.img3.CStride
+			dst, stride = d.img3.Cb[h*by*d.img3.CStride+w*bx:], d.img3.CStride
 		case 2:
-			dst, stride = d.img3.Cr[d.dctSizeScaled*(by*d.img3.CStride+bx):], d.img3.CStride
+			dst, stride = d.img3.Cr[h*by*d.img3.CStride+w*bx:], d.img3.CStride
 		case 3:
-			dst, stride = d.blackPix[d.dctSizeScaled*(by*d.blackStride+bx):], d.blackStride
+			dst, stride = d.blackPix[h*by*d.blackStride+w*bx:], d.blackStride
 		default:
 			return UnsupportedError("too many components")
 		}
 	}
 
+	if n == 2 {
+		// Scale the samples to 16 bits by replicating their high bits, and
+		// store them big-endian.
+		shift := uint(16 - d.precision)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				c := pix[y*w+x]
+				c = c<<shift | c>>(uint(d.precision)-shift)
+				dst[y*stride+2*x] = uint8(c >> 8)
+				dst[y*stride+2*x+1] = uint8(c)
+			}
+		}
+		return nil
+	}
+
 	// write to dst.
-	for y := 0; y < d.dctSizeScaled; y++ {
-		yRow := y * d.dctSizeScaled
+	for y := 0; y < h; y++ {
+		yRow := y * w
 		yStride := y * stride
-		for x := 0; x < d.dctSizeScaled; x++ {
-			c := b[yRow+x]
+		for x := 0; x < w; x++ {
+			c := pix[yRow+x]
 			dst[yStride+x] = uint8(c)
 		}
 	}
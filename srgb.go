@@ -0,0 +1,287 @@
+package jpegscaled
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/m8rge/go-scaled-jpeg/internal/imageutil"
+)
+
+// xyzD50ToSRGB converts CIE XYZ, relative to the D50 white point of the ICC
+// profile connection space, to linear sRGB. It is the inverse of the sRGB
+// primaries' matrix, chromatically adapted to D50 with the Bradford method.
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// srgbEncodeSize is the number of entries in srgbEncode.
+const srgbEncodeSize = 4096
+
+// srgbEncode maps linear sRGB values in [0, 1], scaled by srgbEncodeSize-1,
+// to 8-bit sRGB samples.
+var srgbEncode = func() (t [srgbEncodeSize]uint8) {
+	for i := range t {
+		t[i] = uint8(math.Round(255 * srgbOETF(float64(i)/(srgbEncodeSize-1))))
+	}
+	return t
+}()
+
+// srgbOETF applies the sRGB transfer function to a linear value in [0, 1].
+func srgbOETF(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// srgbEOTF inverts srgbOETF.
+func srgbEOTF(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// rgbToSRGB converts RGB samples described by a matrix/TRC ICC profile, as
+// specified in the ICC specification, section F.3, to sRGB.
+type rgbToSRGB struct {
+	// lin holds the linear value of every 8-bit sample, per channel.
+	lin [3][256]float32
+	// m converts linear RGB to linear sRGB.
+	m [3][3]float32
+}
+
+// newRGBToSRGB returns the conversion from the colour space of an RGB ICC
+// profile to sRGB. It returns false if the profile is not a matrix/TRC RGB
+// profile, or if it describes sRGB, so that no conversion is needed.
+func newRGBToSRGB(profile []byte) (*rgbToSRGB, bool) {
+	tags, ok := iccTags(profile)
+	if !ok || string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+		return nil, false
+	}
+
+	c := new(rgbToSRGB)
+	var primaries [3][3]float64 // Columns are the XYZ of the red, green and blue primaries.
+	var curves [3]func(float64) float64
+	for ch, name := range []string{"r", "g", "b"} {
+		xyz, ok := parseXYZ(tags[name+"XYZ"])
+		if !ok {
+			return nil, false
+		}
+		for i := range xyz {
+			primaries[i][ch] = xyz[i]
+		}
+		if curves[ch], ok = parseTRC(tags[name+"TRC"]); !ok {
+			return nil, false
+		}
+	}
+
+	isSRGB := true
+	for i := range c.m {
+		for j := range c.m[i] {
+			v := 0.0
+			for k := range primaries {
+				v += xyzD50ToSRGB[i][k] * primaries[k][j]
+			}
+			c.m[i][j] = float32(v)
+			id := 0.0
+			if i == j {
+				id = 1
+			}
+			// s15Fixed16 primaries are accurate to about 1e-5.
+			isSRGB = isSRGB && math.Abs(v-id) < 2e-3
+		}
+	}
+	for ch := range c.lin {
+		for x := range c.lin[ch] {
+			v := curves[ch](float64(x) / 255)
+			c.lin[ch][x] = float32(v)
+			isSRGB = isSRGB && math.Abs(v-srgbEOTF(float64(x)/255)) < 1e-3
+		}
+	}
+	if isSRGB {
+		return nil, false
+	}
+	return c, true
+}
+
+// iccTags returns the tags of an ICC profile by signature.
+func iccTags(profile []byte) (map[string][]byte, bool) {
+	if len(profile) < 132 {
+		return nil, false
+	}
+	tags := make(map[string][]byte)
+	n := binary.BigEndian.Uint32(profile[128:])
+	for i := uint64(0); i < uint64(n); i++ {
+		p := 132 + 12*i
+		if p+12 > uint64(len(profile)) {
+			return nil, false
+		}
+		e := profile[p : p+12]
+		offset, size := uint64(binary.BigEndian.Uint32(e[4:])), uint64(binary.BigEndian.Uint32(e[8:]))
+		if offset+size > uint64(len(profile)) {
+			return nil, false
+		}
+		tags[string(e[:4])] = profile[offset : offset+size]
+	}
+	return tags, true
+}
+
+// parseXYZ parses an XYZType tag with a single value.
+func parseXYZ(tag []byte) (xyz [3]float64, ok bool) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+4*i:])
+	}
+	return xyz, true
+}
+
+// paraParams is the number of parameters of every parametricCurveType
+// function type.
+var paraParams = [...]int{1, 3, 4, 5, 7}
+
+// parseTRC parses a curveType or parametricCurveType tag into a function
+// from encoded to linear values, both in [0, 1].
+func parseTRC(tag []byte) (func(float64) float64, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := uint64(binary.BigEndian.Uint32(tag[8:]))
+		if 12+2*n > uint64(len(tag)) {
+			return nil, false
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, true
+		case 1:
+			g := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, true
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return tableCurve(table), true
+	case "para":
+		fn := int(binary.BigEndian.Uint16(tag[8:]))
+		if fn >= len(paraParams) || len(tag) < 12+4*paraParams[fn] {
+			return nil, false
+		}
+		// The parameters are g, a, b, c, d, e, f, as named in the ICC
+		// specification, section 10.18. Unused ones keep the values that make
+		// every function type a special case of type 4.
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < paraParams[fn]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch fn {
+		case 1, 2:
+			// Below -b/a, the curve is c, which is 0 for type 1.
+			d = -b / a
+			if fn == 2 {
+				e, f = c, c
+			}
+			c = 0
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(max(a*x+b, 0), g) + e
+			}
+			return c*x + f
+		}, true
+	}
+	return nil, false
+}
+
+// trcSize returns the size of a curveType or parametricCurveType tag at the
+// start of b, padded to a multiple of 4 bytes, or 0 if it is not one.
+func trcSize(b []byte) int {
+	if len(b) < 12 {
+		return 0
+	}
+	n := 0
+	switch string(b[:4]) {
+	case "curv":
+		n = 12 + 2*int(binary.BigEndian.Uint32(b[8:]))
+	case "para":
+		if fn := int(binary.BigEndian.Uint16(b[8:])); fn < len(paraParams) {
+			n = 12 + 4*paraParams[fn]
+		}
+	}
+	if n == 0 || n > len(b) {
+		return 0
+	}
+	return (n + 3) &^ 3
+}
+
+// tableCurve returns the function that linearly interpolates table, whose
+// entries are evenly spaced over [0, 1]. The table has at least 2 entries.
+func tableCurve(table []float64) func(float64) float64 {
+	n := len(table)
+	return func(x float64) float64 {
+		p := min(max(x, 0), 1) * float64(n-1)
+		i := min(int(p), n-2)
+		return table[i] + (p-float64(i))*(table[i+1]-table[i])
+	}
+}
+
+// s15Fixed16 decodes a big-endian s15Fixed16Number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// apply converts the RGBA image m to sRGB in place.
+func (c *rgbToSRGB) apply(m *image.RGBA) {
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(pix); i += 4 {
+			r, g, bl := c.lin[0][pix[i]], c.lin[1][pix[i+1]], c.lin[2][pix[i+2]]
+			for ch := 0; ch < 3; ch++ {
+				v := c.m[ch][0]*r + c.m[ch][1]*g + c.m[ch][2]*bl
+				k := int(v*(srgbEncodeSize-1) + 0.5)
+				pix[i+ch] = srgbEncode[min(max(k, 0), srgbEncodeSize-1)]
+			}
+		}
+	}
+}
+
+// convertToSRGB returns m converted to sRGB from the colour space of the ICC
+// profile. It returns m unchanged if the profile is not a matrix/TRC RGB
+// profile other than sRGB, or if m is not an 8-bit RGB or YCbCr image.
+func convertToSRGB(m image.Image, profile []byte) image.Image {
+	c, ok := newRGBToSRGB(profile)
+	if !ok {
+		return m
+	}
+	var rgba *image.RGBA
+	switch m := m.(type) {
+	case *image.RGBA:
+		rgba = m
+	case *image.YCbCr:
+		b := m.Bounds()
+		rgba = image.NewRGBA(b)
+		if !imageutil.DrawYCbCr(rgba, b, m, b.Min) {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					yc := m.YCbCrAt(x, y)
+					r, g, bl := color.YCbCrToRGB(yc.Y, yc.Cb, yc.Cr)
+					rgba.SetRGBA(x, y, color.RGBA{r, g, bl, 0xff})
+				}
+			}
+		}
+	default:
+		return m
+	}
+	c.apply(rgba)
+	return rgba
+}
@@ -0,0 +1,163 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"os"
+	"testing"
+)
+
+// Primaries of sRGB and Display P3, adapted to D50, as XYZ columns of red,
+// green and blue.
+var (
+	srgbPrimaries = [3][3]float64{
+		{0.436066, 0.385147, 0.143066},
+		{0.222488, 0.716873, 0.060608},
+		{0.013916, 0.097076, 0.714096},
+	}
+	displayP3Primaries = [3][3]float64{
+		{0.515102, 0.291965, 0.157153},
+		{0.241196, 0.692239, 0.066574},
+		{-0.001053, 0.041882, 0.784378},
+	}
+)
+
+// srgbPara is a parametricCurveType tag with the sRGB transfer function.
+var srgbPara = paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+
+func appendS15Fixed16(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+}
+
+func paraTag(fn uint16, params ...float64) []byte {
+	tag := append([]byte("para"), 0, 0, 0, 0)
+	tag = binary.BigEndian.AppendUint16(tag, fn)
+	tag = append(tag, 0, 0)
+	for _, p := range params {
+		tag = appendS15Fixed16(tag, p)
+	}
+	return tag
+}
+
+// matrixTRCProfile returns an RGB ICC profile with the given primaries and
+// the same TRC tag for every channel.
+func matrixTRCProfile(primaries [3][3]float64, trc []byte) []byte {
+	tags := map[string][]byte{"rTRC": trc, "gTRC": trc, "bTRC": trc}
+	for ch, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag := append([]byte("XYZ "), 0, 0, 0, 0)
+		for i := range primaries {
+			tag = appendS15Fixed16(tag, primaries[i][ch])
+		}
+		tags[name] = tag
+	}
+	names := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	profile := make([]byte, 128)
+	copy(profile[16:], "RGB XYZ ")
+	copy(profile[36:], "acsp")
+	profile = binary.BigEndian.AppendUint32(profile, uint32(len(names)))
+	offset := len(profile) + 12*len(names)
+	var data []byte
+	for _, name := range names {
+		profile = append(profile, name...)
+		profile = binary.BigEndian.AppendUint32(profile, uint32(offset+len(data)))
+		profile = binary.BigEndian.AppendUint32(profile, uint32(len(tags[name])))
+		data = append(data, tags[name]...)
+	}
+	profile = append(profile, data...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func TestParseTRC(t *testing.T) {
+	table := append([]byte("curv"), 0, 0, 0, 0)
+	table = binary.BigEndian.AppendUint32(table, 1024)
+	for i := 0; i < 1024; i++ {
+		table = binary.BigEndian.AppendUint16(table, uint16(math.Round(65535*srgbEOTF(float64(i)/1023))))
+	}
+	gamma := append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 1, 2, 0x33) // 563/256, as in Adobe RGB.
+	for _, tc := range []struct {
+		desc string
+		tag  []byte
+		want func(float64) float64
+	}{
+		{"para type 3", srgbPara, srgbEOTF},
+		{"para type 4", paraTag(4, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045, 0, 0), srgbEOTF},
+		{"para type 0", paraTag(0, 1.8), func(x float64) float64 { return math.Pow(x, 1.8) }},
+		{"curv table", table, srgbEOTF},
+		{"curv gamma", gamma, func(x float64) float64 { return math.Pow(x, 563.0/256) }},
+	} {
+		f, ok := parseTRC(tc.tag)
+		if !ok {
+			t.Errorf("%s: not parsed", tc.desc)
+			continue
+		}
+		for i := 0; i <= 255; i++ {
+			x := float64(i) / 255
+			if got, want := f(x), tc.want(x); math.Abs(got-want) > 1e-4 {
+				t.Errorf("%s: f(%v) = %v, want %v", tc.desc, x, got, want)
+				break
+			}
+		}
+	}
+}
+
+// TestConvertToSRGB tests converting a Display P3 image to sRGB against the
+// published linear P3 to sRGB matrix, and that sRGB images are unchanged.
+func TestConvertToSRGB(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DecodeOptions{DCTSizeScaled: 4, Crop: image.Rect(10, 10, 130, 90)}
+	plain, err := Decode(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.ConvertToSRGB = true
+
+	srgb := insertSegment(data, iccSegment(matrixTRCProfile(srgbPrimaries, srgbPara), 1, 1))
+	m, err := Decode(bytes.NewReader(srgb), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*image.YCbCr); !ok {
+		t.Errorf("sRGB: got %T, want *image.YCbCr", m)
+	}
+	if err := equalImages(m, plain); err != nil {
+		t.Errorf("sRGB: %v", err)
+	}
+
+	p3 := insertSegment(data, iccSegment(matrixTRCProfile(displayP3Primaries, srgbPara), 1, 1))
+	m, err = Decode(bytes.NewReader(p3), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != plain.Bounds() {
+		t.Fatalf("P3: got bounds %v, want %v", m.Bounds(), plain.Bounds())
+	}
+	p3ToSRGB := [3][3]float64{
+		{1.2249, -0.2247, 0},
+		{-0.0420, 1.0419, 0},
+		{-0.0197, -0.0786, 1.0979},
+	}
+	b := plain.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := plain.At(x, y).RGBA()
+			lin := [3]float64{srgbEOTF(float64(r>>8) / 255), srgbEOTF(float64(g>>8) / 255), srgbEOTF(float64(bl>>8) / 255)}
+			var want [3]float64
+			for i := range want {
+				v := p3ToSRGB[i][0]*lin[0] + p3ToSRGB[i][1]*lin[1] + p3ToSRGB[i][2]*lin[2]
+				want[i] = 255 * srgbOETF(min(max(v, 0), 1))
+			}
+			r, g, bl, _ = m.At(x, y).RGBA()
+			for i, got := range []uint32{r >> 8, g >> 8, bl >> 8} {
+				if math.Abs(float64(got)-want[i]) > 2 {
+					t.Fatalf("P3: pixel (%d, %d) channel %d: got %d, want %.1f", x, y, i, got, want[i])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package jpegscaled
+
+// makePlanes allocates the planes that the components are decoded into when
+// their sampling factors have no direct layout. Like the image, they only
+// cover the MCUs intersecting the crop rectangle.
+func (d *decoder) makePlanes() {
+	n := 1
+	if d.precision > 8 {
+		n = 2
+	}
+	for i := 0; i < d.nComp; i++ {
+		c := &d.comp[i]
+		w := d.cropMCU.Dx() * c.h * d.dctSizeScaledX
+		h := d.cropMCU.Dy() * c.v * d.dctSizeScaledY
+		d.planes[i] = make([]byte, n*w*h)
+		d.planeStride[i] = n * w
+	}
+}
+
+// upsamplePlanes upsamples every component's plane to the full resolution
+// of the image, replicating the samples as libjpeg's int_upsample does.
+// Components whose sampling factors do not divide the largest ones are
+// upsampled by the nearest sample to the left and above.
+func (d *decoder) upsamplePlanes() {
+	n := 1
+	if d.precision > 8 {
+		n = 2
+	}
+	for i := 0; i < d.nComp; i++ {
+		var dst []byte
+		var stride, w, h int
+		switch {
+		case d.precision > 8:
+			m := d.img16[i]
+			dst, stride, w, h = m.Pix, m.Stride, m.Rect.Dx(), m.Rect.Dy()
+		default:
+			b := d.img3.Rect
+			w, h = b.Dx(), b.Dy()
+			switch i {
+			case 0:
+				dst, stride = d.img3.Y, d.img3.YStride
+			case 1:
+				dst, stride = d.img3.Cb, d.img3.CStride
+			case 2:
+				dst, stride = d.img3.Cr, d.img3.CStride
+			case 3:
+				dst, stride = d.blackPix, d.blackStride
+			}
+		}
+		// The image and the planes start at the same MCU, so a sample at
+		// (x, y) relative to the image is at (x * h / hMax, y * v / vMax)
+		// relative to the plane.
+		c := &d.comp[i]
+		src, srcStride := d.planes[i], d.planeStride[i]
+		xs := make([]int, w)
+		for x := range xs {
+			xs[x] = n * (x * c.h / d.hMax)
+		}
+		for y := 0; y < h; y++ {
+			row := src[y*c.v/d.vMax*srcStride:]
+			out := dst[y*stride : y*stride+n*w]
+			if n == 1 {
+				for x, sx := range xs {
+					out[x] = row[sx]
+				}
+				continue
+			}
+			for x, sx := range xs {
+				out[2*x], out[2*x+1] = row[sx], row[sx+1]
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package jpegscaled
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// upsampleTests are images whose sampling factors have no direct layout,
+// with golden images decoded by libjpeg without fancy upsampling.
+var upsampleTests = []imageTest{
+	// Luma 1x2, chroma 2x2.
+	{"testdata/video-001.q50.122222", "testdata/video-001.q50.122222.jpeg"},
+	{"testdata/video-001.q50.122222", "testdata/video-001.q50.122222.progressive.jpeg"},
+	// Luma 3x1, chroma 1x1.
+	{"testdata/video-001.q50.311111", "testdata/video-001.q50.311111.jpeg"},
+	// Luma 2x2, Cb 2x1 and Cr 1x1.
+	{"testdata/video-001.q50.222111", "testdata/video-001.q50.222111.jpeg"},
+}
+
+// TestDecodeUpsampled tests that images with unusual sampling factors match
+// libjpeg at full size, and stay close to the golden images when scaled.
+func TestDecodeUpsampled(t *testing.T) {
+	for _, it := range upsampleTests {
+		g, err := decodeStd(it.goldenFilename + "#8.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := g.Bounds()
+		for _, size := range []int{8, 4, 2, 1} {
+			m, err := decodeJpegScaled(it.filename, size)
+			if err != nil {
+				t.Errorf("%s #%d: %v", it.filename, size, err)
+				continue
+			}
+			want := image.Rect(0, 0, b.Dx()*size/DCTSIZE, b.Dy()*size/DCTSIZE)
+			if m.Bounds() != want {
+				t.Errorf("%s #%d: got bounds %v, want %v", it.filename, size, m.Bounds(), want)
+				continue
+			}
+			if _, ok := m.(*image.YCbCr); !ok {
+				t.Errorf("%s #%d: got %T, want *image.YCbCr", it.filename, size, m)
+			}
+			if size == 8 {
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					for x := b.Min.X; x < b.Max.X; x++ {
+						if !withinTolerance(g.At(x, y), m.At(x, y), 2<<8) {
+							t.Errorf("%s: at (%d, %d):\ngot  %v\nwant %v", it.filename, x, y, rgba(m.At(x, y)), rgba(g.At(x, y)))
+							break
+						}
+					}
+				}
+				continue
+			}
+			// Compare the scaled image with the golden one at the same
+			// scale, on average. Subsampled luma loses more detail when
+			// scaled than averaging the golden image does, hence the
+			// allowance.
+			if diff := meanDiff(m, g, size); diff > 12 {
+				t.Errorf("%s #%d: mean difference %.1f", it.filename, size, diff)
+			}
+		}
+	}
+}
+
+// meanDiff returns the mean absolute difference between the RGB channels of
+// m, decoded at a DCT size below 8, and the full size image g averaged over
+// the pixels that every pixel of m covers.
+func meanDiff(m, g image.Image, size int) float64 {
+	sum, n := 0, 0
+	b := m.Bounds()
+	k := DCTSIZE / size
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c0 := color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+			var avg [3]int
+			for sy := y * k; sy < y*k+k; sy++ {
+				for sx := x * k; sx < x*k+k; sx++ {
+					c1 := color.RGBAModel.Convert(g.At(sx, sy)).(color.RGBA)
+					avg[0] += int(c1.R)
+					avg[1] += int(c1.G)
+					avg[2] += int(c1.B)
+				}
+			}
+			for i, v := range []uint8{c0.R, c0.G, c0.B} {
+				d := int(v) - avg[i]/(k*k)
+				sum += max(d, -d)
+				n++
+			}
+		}
+	}
+	return float64(sum) / float64(n)
+}
+
+// TestDecodeConfigUpsampled tests that DecodeConfig reports the sampling
+// factors of images without a direct layout.
+func TestDecodeConfigUpsampled(t *testing.T) {
+	c, err := decodeConfig("testdata/video-001.q50.222111.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(c.Components)
+	if want := "[{1 2 2} {2 2 1} {3 1 1}]"; got != want {
+		t.Errorf("got components %s, want %s", got, want)
+	}
+}
+
+// TestUpsampleFractional tests upsampling by factors that do not divide the
+// largest ones, which libjpeg rejects.
+func TestUpsampleFractional(t *testing.T) {
+	d := &decoder{nComp: 3, precision: 8, hMax: 3, vMax: 1, upsample: true}
+	d.comp = []component{{h: 3, v: 1}, {h: 2, v: 1}, {h: 1, v: 1}}
+	d.img3 = image.NewYCbCr(image.Rect(0, 0, 6, 1), image.YCbCrSubsampleRatio444)
+	d.planes = [][]byte{{0, 1, 2, 3, 4, 5}, {10, 11, 12, 13}, {20, 21}}
+	d.planeStride = []int{6, 4, 2}
+	d.upsamplePlanes()
+	for _, tc := range []struct {
+		name      string
+		got, want []byte
+	}{
+		{"Y", d.img3.Y, []byte{0, 1, 2, 3, 4, 5}},
+		{"Cb", d.img3.Cb, []byte{10, 10, 11, 12, 12, 13}},
+		{"Cr", d.img3.Cr, []byte{20, 20, 20, 21, 21, 21}},
+	} {
+		if !bytes.Equal(tc.got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+// TestDecodeUpsampledCrop tests that cropping images without a direct layout
+// gives the same pixels as cropping the decoded image.
+func TestDecodeUpsampledCrop(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.q50.311111.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := Decode(bytes.NewReader(data), DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crop := image.Rect(50, 17, 121, 60)
+	m, err := Decode(bytes.NewReader(data), DecodeOptions{Crop: crop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalImages(m, full.(*image.YCbCr).SubImage(crop)); err != nil {
+		t.Error(err)
+	}
+}